@@ -0,0 +1,436 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bits are numbered from the most significant bit of the first byte, as in
+// Redis. Offsets are limited to strings of 512MB.
+const maxBitOffset = 512*1024*1024*8 - 1
+
+func parseBitOffset(arg string) (int, bool) {
+	offset, err := strconv.ParseUint(arg, 10, 64)
+	return int(offset), err == nil && offset <= maxBitOffset
+}
+
+func getBit(b []byte, offset int) int {
+	if offset>>3 >= len(b) {
+		return 0
+	}
+	return int(b[offset>>3]>>(7-offset&7)) & 1
+}
+
+func setBit(b []byte, offset int, bit int) {
+	mask := byte(1) << (7 - offset&7)
+	if bit == 1 {
+		b[offset>>3] |= mask
+	} else {
+		b[offset>>3] &^= mask
+	}
+}
+
+func handleSetbit(c *Client, args []string) error {
+	offset, ok := parseBitOffset(args[2])
+	if !ok {
+		return respWriter(c, ERROR, "ERR bit offset is not an integer or out of range")
+	}
+	if args[3] != "0" && args[3] != "1" {
+		return respWriter(c, ERROR, "ERR bit is not an integer or out of range")
+	}
+	b, err := GlobalStore.bytesForWrite(args[1], offset>>3+1)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	old := getBit(b, offset)
+	setBit(b, offset, int(args[3][0]-'0'))
+	return respWriter(c, INTEGER, strconv.Itoa(old))
+}
+
+func handleGetbit(c *Client, args []string) error {
+	offset, ok := parseBitOffset(args[2])
+	if !ok {
+		return respWriter(c, ERROR, "ERR bit offset is not an integer or out of range")
+	}
+	b, err := GlobalStore.stringBytes(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(getBit(b, offset)))
+}
+
+// parseBitRange parses the start end [BYTE | BIT] range of BITCOUNT and
+// BITPOS over a string of size bytes into a range of bit offsets. end
+// defaults to the end of the string if it is missing. msg is the error
+// reply if the arguments are invalid. An empty range has first > last.
+func parseBitRange(args []string, size int) (first, last int, msg string) {
+	start, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, 0, "ERR value is not an integer or out of range"
+	}
+	end, unit := -1, 8
+	if len(args) > 1 {
+		if end, err = strconv.Atoi(args[1]); err != nil {
+			return 0, 0, "ERR value is not an integer or out of range"
+		}
+	}
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			unit = 1
+		default:
+			return 0, 0, "ERR syntax error"
+		}
+	}
+	if len(args) > 3 {
+		return 0, 0, "ERR syntax error"
+	}
+	total := size * 8 / unit
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	start, end = max(start, 0), min(end, total-1)
+	if start > end {
+		return 1, 0, ""
+	}
+	return start * unit, end*unit + unit - 1, ""
+}
+
+// handleBitcount implements BITCOUNT key [start end [BYTE | BIT]].
+func handleBitcount(c *Client, args []string) error {
+	if len(args) == 3 {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	b, err := GlobalStore.stringBytes(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	first, last := 0, len(b)*8-1
+	if len(args) > 2 {
+		var msg string
+		if first, last, msg = parseBitRange(args[2:], len(b)); msg != "" {
+			return respWriter(c, ERROR, msg)
+		}
+	}
+	count := 0
+	for offset := first; offset <= last; {
+		if offset&7 == 0 && offset+7 <= last {
+			count += bits.OnesCount8(b[offset>>3])
+			offset += 8
+			continue
+		}
+		count += getBit(b, offset)
+		offset++
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(count))
+}
+
+// handleBitpos implements BITPOS key bit [start [end [BYTE | BIT]]]. When
+// looking for a clear bit without an end, the string counts as padded with
+// zeros, so a string of only set bits replies with the bit after its end.
+func handleBitpos(c *Client, args []string) error {
+	if args[2] != "0" && args[2] != "1" {
+		return respWriter(c, ERROR, "ERR The bit argument must be 1 or 0.")
+	}
+	bit := int(args[2][0] - '0')
+	b, err := GlobalStore.stringBytes(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	first, last := 0, len(b)*8-1
+	if len(args) > 3 {
+		var msg string
+		if first, last, msg = parseBitRange(args[3:], len(b)); msg != "" {
+			return respWriter(c, ERROR, msg)
+		}
+	}
+	if len(b) == 0 {
+		return respWriter(c, INTEGER, strconv.Itoa(-bit))
+	}
+	// Bytes with no bit of the kind looked for are skipped whole.
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for offset := first; offset <= last; {
+		if offset&7 == 0 && offset+7 <= last && b[offset>>3] == skip {
+			offset += 8
+			continue
+		}
+		if getBit(b, offset) == bit {
+			return respWriter(c, INTEGER, strconv.Itoa(offset))
+		}
+		offset++
+	}
+	if bit == 0 && len(args) <= 4 && first <= last {
+		return respWriter(c, INTEGER, strconv.Itoa(last+1))
+	}
+	return respWriter(c, INTEGER, "-1")
+}
+
+// handleBitop implements BITOP AND | OR | XOR | NOT destkey key [key ...].
+// Shorter strings count as padded with zero bytes.
+func handleBitop(c *Client, args []string) error {
+	op, dst, keys := strings.ToUpper(args[1]), args[2], args[3:]
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(keys) != 1 {
+			return respWriter(c, ERROR, "ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	srcs := make([][]byte, len(keys))
+	size := 0
+	for i, key := range keys {
+		b, err := GlobalStore.stringBytes(key)
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		srcs[i] = b
+		size = max(size, len(b))
+	}
+	result := make([]byte, size)
+	for i := range result {
+		var v byte
+		for j, src := range srcs {
+			var sv byte
+			if i < len(src) {
+				sv = src[i]
+			}
+			switch {
+			case op == "NOT":
+				v = ^sv
+			case j == 0:
+				v = sv
+			case op == "AND":
+				v &= sv
+			case op == "OR":
+				v |= sv
+			case op == "XOR":
+				v ^= sv
+			}
+		}
+		result[i] = v
+	}
+	if size == 0 {
+		GlobalStore.Delete(dst)
+	} else {
+		GlobalStore.Set(dst, string(result), time.Time{})
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(size))
+}
+
+// bitfieldType is an integer type of BITFIELD, such as i8 or u16.
+type bitfieldType struct {
+	signed bool
+	bits   int
+}
+
+func parseBitfieldType(arg string) (bitfieldType, bool) {
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'I' && arg[0] != 'u' && arg[0] != 'U') {
+		return bitfieldType{}, false
+	}
+	t := bitfieldType{signed: arg[0] == 'i' || arg[0] == 'I'}
+	n, err := strconv.Atoi(arg[1:])
+	if err != nil || n < 1 || (t.signed && n > 64) || (!t.signed && n > 63) {
+		return bitfieldType{}, false
+	}
+	t.bits = n
+	return t, true
+}
+
+// parseBitfieldOffset parses an offset of BITFIELD; #n means n times the
+// width of the type.
+func parseBitfieldOffset(arg string, t bitfieldType) (int, bool) {
+	multiply := strings.HasPrefix(arg, "#")
+	if multiply {
+		arg = arg[1:]
+	}
+	offset, ok := parseBitOffset(arg)
+	if multiply {
+		offset *= t.bits
+	}
+	return offset, ok && offset+t.bits-1 <= maxBitOffset
+}
+
+func getBitfield(b []byte, offset int, t bitfieldType) int64 {
+	var v uint64
+	for i := 0; i < t.bits; i++ {
+		v = v<<1 | uint64(getBit(b, offset+i))
+	}
+	if t.signed && t.bits < 64 && v&(1<<(t.bits-1)) != 0 {
+		v |= math.MaxUint64 << t.bits
+	}
+	return int64(v)
+}
+
+func setBitfield(b []byte, offset int, t bitfieldType, value int64) {
+	for i := 0; i < t.bits; i++ {
+		setBit(b, offset+i, int(uint64(value)>>(t.bits-1-i))&1)
+	}
+}
+
+// Overflow behaviours of BITFIELD.
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfieldAdd adds incr to value, which has type t, handling overflow as
+// Redis does. ok is false if the result does not fit and overflow is FAIL.
+func bitfieldAdd(value, incr int64, t bitfieldType, overflow int) (int64, bool) {
+	var maxV, minV int64
+	if t.signed {
+		maxV = int64(uint64(1)<<(t.bits-1) - 1)
+		minV = -maxV - 1
+	} else {
+		maxV = int64(uint64(1)<<t.bits - 1)
+	}
+	wrapped := func() int64 {
+		res := uint64(value) + uint64(incr)
+		if t.bits == 64 {
+			return int64(res)
+		}
+		mask := uint64(math.MaxUint64) << t.bits
+		if t.signed && res&(1<<(t.bits-1)) != 0 {
+			return int64(res | mask)
+		}
+		return int64(res &^ mask)
+	}
+	var over, under bool
+	if t.signed {
+		maxIncr, minIncr := maxV-value, minV-value
+		over = value > maxV || (t.bits != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr)
+		under = value < minV || (t.bits != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr)
+	} else {
+		over = uint64(value) > uint64(maxV) || (incr > 0 && uint64(incr) > uint64(maxV)-uint64(value))
+		under = incr < 0 && uint64(-incr) > uint64(value)
+	}
+	switch {
+	case !over && !under:
+		return value + incr, true
+	case overflow == overflowFail:
+		return 0, false
+	case overflow == overflowWrap:
+		return wrapped(), true
+	case over:
+		return maxV, true
+	}
+	return minV, true
+}
+
+type bitfieldOp struct {
+	cmd      string
+	t        bitfieldType
+	offset   int
+	value    int64
+	overflow int
+}
+
+// handleBitfield implements BITFIELD key [GET type offset]
+// [SET type offset value] [INCRBY type offset increment]
+// [OVERFLOW WRAP | SAT | FAIL] ... and BITFIELD_RO, which only allows GET.
+func handleBitfield(c *Client, args []string) error {
+	readonly := strings.EqualFold(args[0], "bitfield_ro")
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	size := 0
+	for i := 2; i < len(args); i++ {
+		cmd := strings.ToUpper(args[i])
+		if cmd == "OVERFLOW" && i+1 < len(args) {
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return respWriter(c, ERROR, "ERR Invalid OVERFLOW type specified")
+			}
+			i++
+			continue
+		}
+		argc := 3
+		if cmd == "GET" {
+			argc = 2
+		}
+		if (cmd != "GET" && cmd != "SET" && cmd != "INCRBY") || i+argc >= len(args) {
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+		if readonly && cmd != "GET" {
+			return respWriter(c, ERROR, "ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		t, ok := parseBitfieldType(args[i+1])
+		if !ok {
+			return respWriter(c, ERROR, "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		offset, ok := parseBitfieldOffset(args[i+2], t)
+		if !ok {
+			return respWriter(c, ERROR, "ERR bit offset is not an integer or out of range")
+		}
+		op := bitfieldOp{cmd: cmd, t: t, offset: offset, overflow: overflow}
+		if cmd != "GET" {
+			value, err := strconv.ParseInt(args[i+3], 10, 64)
+			if err != nil {
+				return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+			}
+			op.value = value
+			size = max(size, (offset+t.bits+7)/8)
+		}
+		ops = append(ops, op)
+		i += argc
+	}
+	var b []byte
+	var err error
+	if size > 0 {
+		b, err = GlobalStore.bytesForWrite(args[1], size)
+	} else {
+		b, err = GlobalStore.stringBytes(args[1])
+	}
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if err := respArrayLen(c, len(ops)); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		old := getBitfield(b, op.offset, op.t)
+		var v int64
+		ok := true
+		switch op.cmd {
+		case "GET":
+			v = old
+		case "SET":
+			var set int64
+			if set, ok = bitfieldAdd(op.value, 0, op.t, op.overflow); ok {
+				setBitfield(b, op.offset, op.t, set)
+			}
+			v = old
+		case "INCRBY":
+			if v, ok = bitfieldAdd(old, op.value, op.t, op.overflow); ok {
+				setBitfield(b, op.offset, op.t, v)
+			}
+		}
+		if !ok {
+			if _, err := c.Write([]byte("$-1\r\n")); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := respWriter(c, INTEGER, strconv.FormatInt(v, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
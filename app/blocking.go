@@ -0,0 +1,110 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// Blocking commands (BLPOP, XREAD BLOCK, ...) register the client here for
+// the keys they wait on. Commands that may make a key ready, such as a push
+// to a list or XADD, signal it; once the command (or transaction) finished,
+// ServeReadyKeys offers every signalled key to the clients blocked on it,
+// longest waiting first. Serving happens with the store lock held, so an
+// element is handed to exactly one client and never to one that already
+// timed out or went away.
+
+// blockedClient is a client waiting in a blocking command.
+type blockedClient struct {
+	keys []string
+	// serve is called with the store lock held when key may have become
+	// ready. It does the work of the command, e.g. pops the element and
+	// keeps it for the reply, and reports whether the client was served.
+	// Returning false leaves the client blocked.
+	serve  func(key string) bool
+	served bool
+	wake   chan struct{}
+}
+
+// Block registers a client waiting for any of keys.
+func (s *Store) Block(keys []string, serve func(key string) bool) *blockedClient {
+	b := &blockedClient{keys: keys, serve: serve, wake: make(chan struct{})}
+	for _, key := range keys {
+		if slices.Contains(s.blocked[key], b) {
+			continue
+		}
+		s.blocked[key] = append(s.blocked[key], b)
+	}
+	return b
+}
+
+// Unblock removes b from the queues of all its keys.
+func (s *Store) Unblock(b *blockedClient) {
+	for _, key := range b.keys {
+		waiters := slices.DeleteFunc(s.blocked[key], func(w *blockedClient) bool { return w == b })
+		if len(waiters) == 0 {
+			delete(s.blocked, key)
+		} else {
+			s.blocked[key] = waiters
+		}
+	}
+}
+
+// signalKeyReady records that key may now satisfy clients blocked on it.
+func (s *Store) signalKeyReady(key string) {
+	if len(s.blocked[key]) == 0 || s.readyKeys[key] {
+		return
+	}
+	s.readyKeys[key] = true
+	s.readyQueue = append(s.readyQueue, key)
+}
+
+// ServeReadyKeys serves the clients blocked on the keys signalled since the
+// last call. Serving a client may signal further keys (BLMOVE pushes to its
+// destination), which are served in the same call.
+func (s *Store) ServeReadyKeys() {
+	for len(s.readyQueue) > 0 {
+		key := s.readyQueue[0]
+		s.readyQueue = s.readyQueue[1:]
+		delete(s.readyKeys, key)
+		for _, b := range slices.Clone(s.blocked[key]) {
+			if b.served || !b.serve(key) {
+				continue
+			}
+			b.served = true
+			s.Unblock(b)
+			close(b.wake)
+		}
+	}
+}
+
+// canBlock reports whether the client may wait in a blocking command. A
+// transaction must not block, so inside EXEC a blocking command that finds
+// nothing to serve replies at once with its null reply.
+func (c *Client) canBlock() bool {
+	return !c.inExec
+}
+
+// blockOn waits, with the store lock released, until serve succeeds for one
+// of keys, the timeout passes (never for 0) or the connection is closed. It
+// reports whether the client was served; otherwise the command should reply
+// as if it timed out.
+func (c *Client) blockOn(keys []string, timeout time.Duration, serve func(key string) bool) bool {
+	b := GlobalStore.Block(keys, serve)
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	GlobalStore.Unlocked(func() {
+		select {
+		case <-b.wake:
+		case <-expired:
+		case <-c.closed:
+		}
+	})
+	if !b.served {
+		GlobalStore.Unblock(b)
+	}
+	return b.served
+}
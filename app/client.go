@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"net"
+)
+
+// replyBufferSize is how many bytes of replies to pipelined commands are
+// collected before they are sent without waiting for the rest of the
+// pipeline. A buffer that grew past it for a large reply is dropped once
+// sent.
+const replyBufferSize = 64 * 1024
+
+// Client holds the state of a single connection.
+type Client struct {
+	conn   net.Conn
+	reader *respReader
+
+	// Replies are built in out while the store lock is held and only
+	// written to conn, which blocks on a client that does not read, once
+	// the lock is released.
+	out bytes.Buffer
+
+	// Commands are read by a goroutine of their own, so that a client
+	// waiting in a blocking command notices when the connection is closed.
+	// readErr is the error that ended reading; it may only be looked at
+	// once commands is closed.
+	commands chan []string
+	closed   chan struct{}
+	done     chan struct{}
+	readErr  error
+
+	// Transaction state. Between MULTI and EXEC commands are only queued;
+	// multiErr records that one of them was rejected so EXEC must abort.
+	inMulti  bool
+	inExec   bool
+	multiErr bool
+	queued   [][]string
+
+	// watched maps every key the client WATCHes to its state at WATCH time.
+	watched map[string]watchState
+}
+
+type watchState struct {
+	version uint64
+	expired bool
+}
+
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn:     conn,
+		reader:   newRespReader(conn),
+		commands: make(chan []string, 64),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// readCommands reads commands into c.commands until the connection fails or
+// the client is done. A read error closes both c.commands and c.closed.
+func (c *Client) readCommands() {
+	defer close(c.commands)
+	for {
+		args, err := c.reader.ReadCommand()
+		if err != nil {
+			c.readErr = err
+			close(c.closed)
+			return
+		}
+		select {
+		case c.commands <- args:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Write buffers a reply; it is sent by Flush, which must not be called with
+// the store lock held.
+func (c *Client) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+// Buffered returns the number of bytes of replies not sent yet.
+func (c *Client) Buffered() int {
+	return c.out.Len()
+}
+
+func (c *Client) Flush() error {
+	_, err := c.out.WriteTo(c.conn)
+	if c.out.Cap() > replyBufferSize {
+		c.out = bytes.Buffer{}
+	}
+	return err
+}
+
+// flagTransactionError marks the open transaction, if any, so that EXEC
+// refuses to run it.
+func (c *Client) flagTransactionError() {
+	if c.inMulti {
+		c.multiErr = true
+	}
+}
+
+func (c *Client) discardTransaction() {
+	c.inMulti = false
+	c.multiErr = false
+	c.queued = nil
+}
+
+// The watch methods must be called with the store lock held.
+
+func (c *Client) watch(key string) {
+	if _, ok := c.watched[key]; ok {
+		return
+	}
+	if c.watched == nil {
+		c.watched = make(map[string]watchState)
+	}
+	c.watched[key] = watchState{
+		version: GlobalStore.Watch(key),
+		expired: GlobalStore.IsExpired(key),
+	}
+}
+
+func (c *Client) unwatchAll() {
+	for key := range c.watched {
+		GlobalStore.Unwatch(key)
+	}
+	c.watched = nil
+}
+
+// watchedKeysChanged reports whether a watched key was modified since WATCH.
+// A key whose TTL passed in the meantime counts as modified even if nothing
+// has deleted it yet.
+func (c *Client) watchedKeysChanged() bool {
+	for key, state := range c.watched {
+		if GlobalStore.KeyVersion(key) != state.version {
+			return true
+		}
+		if !state.expired && GlobalStore.IsExpired(key) {
+			return true
+		}
+	}
+	return false
+}
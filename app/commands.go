@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Command flags, reported by COMMAND with the same names Redis uses.
+const (
+	flagWrite       = "write"
+	flagReadonly    = "readonly"
+	flagDenyOOM     = "denyoom"
+	flagFast        = "fast"
+	flagBlocking    = "blocking"
+	flagMovableKeys = "movablekeys"
+	flagNoScript    = "noscript"
+	flagLoading     = "loading"
+	flagStale       = "stale"
+)
+
+// Command describes a command the server understands. Arity follows the
+// Redis convention: a positive value is the exact number of arguments
+// (including the command name), a negative value -N means at least N.
+// FirstKey, LastKey and Step give the positions of the key arguments; a
+// negative LastKey counts from the end. Commands whose keys cannot be found
+// by position set GetKeys instead.
+type Command struct {
+	Name     string
+	Arity    int
+	Flags    []string
+	FirstKey int
+	LastKey  int
+	Step     int
+	GetKeys  func(args []string) []string
+	Handler  func(c *Client, args []string) error
+}
+
+var commandTable = make(map[string]*Command)
+
+func init() {
+	for _, cmd := range []*Command{
+		{Name: "ping", Arity: -1, Flags: []string{flagFast, flagStale}, Handler: handlePing},
+		{Name: "echo", Arity: 2, Flags: []string{flagFast}, Handler: handleEcho},
+		{Name: "command", Arity: -1, Flags: []string{flagLoading, flagStale}, Handler: handleCommand},
+		{Name: "info", Arity: -1, Flags: []string{flagLoading, flagStale}, Handler: handleInfo},
+
+		{Name: "get", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleGet},
+		{Name: "set", Arity: -3, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSet},
+		{Name: "incr", Arity: 2, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleIncr},
+		{Name: "type", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleType},
+
+		{Name: "del", Arity: -2, Flags: []string{flagWrite}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleDel},
+		{Name: "unlink", Arity: -2, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleDel},
+		{Name: "exists", Arity: -2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleExists},
+		{Name: "rename", Arity: 3, Flags: []string{flagWrite}, FirstKey: 1, LastKey: 2, Step: 1, Handler: handleRename},
+		{Name: "renamenx", Arity: 3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 2, Step: 1, Handler: handleRename},
+		{Name: "copy", Arity: -3, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: 2, Step: 1, Handler: handleCopy},
+
+		{Name: "expire", Arity: -3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleExpire},
+		{Name: "pexpire", Arity: -3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleExpire},
+		{Name: "expireat", Arity: -3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleExpire},
+		{Name: "pexpireat", Arity: -3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleExpire},
+		{Name: "ttl", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleTTL},
+		{Name: "pttl", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleTTL},
+		{Name: "expiretime", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleTTL},
+		{Name: "pexpiretime", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleTTL},
+		{Name: "persist", Arity: 2, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handlePersist},
+
+		{Name: "rpush", Arity: -3, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleRpush},
+		{Name: "lpush", Arity: -3, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLPush},
+		{Name: "lrange", Arity: 4, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLRange},
+		{Name: "llen", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLlen},
+		{Name: "lpop", Arity: -2, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLpop},
+		{Name: "rpop", Arity: -2, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLpop},
+		{Name: "lpushx", Arity: -3, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handlePushx},
+		{Name: "rpushx", Arity: -3, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handlePushx},
+		{Name: "lindex", Arity: 3, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLindex},
+		{Name: "lset", Arity: 4, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLset},
+		{Name: "linsert", Arity: 5, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLinsert},
+		{Name: "lrem", Arity: 4, Flags: []string{flagWrite}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLrem},
+		{Name: "ltrim", Arity: 4, Flags: []string{flagWrite}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLtrim},
+		{Name: "lpos", Arity: -3, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleLpos},
+		{Name: "lmove", Arity: 5, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: 2, Step: 1, Handler: handleLmove},
+		{Name: "blmove", Arity: 6, Flags: []string{flagWrite, flagDenyOOM, flagBlocking}, FirstKey: 1, LastKey: 2, Step: 1, Handler: handleLmove},
+		{Name: "lmpop", Arity: -4, Flags: []string{flagWrite, flagMovableKeys}, GetKeys: lmpopKeys, Handler: handleLmpop},
+		{Name: "blmpop", Arity: -5, Flags: []string{flagWrite, flagBlocking, flagMovableKeys}, GetKeys: lmpopKeys, Handler: handleLmpop},
+		{Name: "blpop", Arity: -3, Flags: []string{flagWrite, flagBlocking}, FirstKey: 1, LastKey: -2, Step: 1, Handler: handleBlpop},
+		{Name: "brpop", Arity: -3, Flags: []string{flagWrite, flagBlocking}, FirstKey: 1, LastKey: -2, Step: 1, Handler: handleBlpop},
+
+		{Name: "hset", Arity: -4, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHset},
+		{Name: "hmset", Arity: -4, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHset},
+		{Name: "hsetnx", Arity: 4, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHsetnx},
+		{Name: "hget", Arity: 3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHget},
+		{Name: "hmget", Arity: -3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHmget},
+		{Name: "hgetall", Arity: 2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHgetall},
+		{Name: "hkeys", Arity: 2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHgetall},
+		{Name: "hvals", Arity: 2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHgetall},
+		{Name: "hdel", Arity: -3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHdel},
+		{Name: "hexists", Arity: 3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHexists},
+		{Name: "hlen", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHlen},
+		{Name: "hstrlen", Arity: 3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHstrlen},
+		{Name: "hincrby", Arity: 4, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHincrby},
+		{Name: "hincrbyfloat", Arity: 4, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHincrbyfloat},
+		{Name: "hrandfield", Arity: -2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHrandfield},
+		{Name: "hscan", Arity: -3, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleHscan},
+
+		{Name: "sadd", Arity: -3, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSadd},
+		{Name: "srem", Arity: -3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSrem},
+		{Name: "sismember", Arity: 3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSismember},
+		{Name: "smismember", Arity: -3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSmismember},
+		{Name: "smembers", Arity: 2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSmembers},
+		{Name: "scard", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleScard},
+		{Name: "spop", Arity: -2, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSpop},
+		{Name: "srandmember", Arity: -2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSrandmember},
+		{Name: "smove", Arity: 4, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 2, Step: 1, Handler: handleSmove},
+		{Name: "sinter", Arity: -2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleSetAlgebra},
+		{Name: "sunion", Arity: -2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleSetAlgebra},
+		{Name: "sdiff", Arity: -2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleSetAlgebra},
+		{Name: "sinterstore", Arity: -3, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleSetAlgebra},
+		{Name: "sunionstore", Arity: -3, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleSetAlgebra},
+		{Name: "sdiffstore", Arity: -3, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleSetAlgebra},
+		{Name: "sintercard", Arity: -3, Flags: []string{flagReadonly, flagMovableKeys}, GetKeys: sintercardKeys, Handler: handleSintercard},
+		{Name: "sscan", Arity: -3, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSscan},
+
+		{Name: "zadd", Arity: -4, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZadd},
+		{Name: "zincrby", Arity: 4, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZincrby},
+		{Name: "zrem", Arity: -3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZrem},
+		{Name: "zscore", Arity: 3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZscore},
+		{Name: "zmscore", Arity: -3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZscore},
+		{Name: "zcard", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZcard},
+		{Name: "zcount", Arity: 4, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZcount},
+		{Name: "zrank", Arity: -3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZrank},
+		{Name: "zrevrank", Arity: -3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZrank},
+		{Name: "zrange", Arity: -4, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleZrange},
+
+		{Name: "pfadd", Arity: -2, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handlePfadd},
+		{Name: "pfcount", Arity: -2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handlePfcount},
+		{Name: "pfmerge", Arity: -2, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handlePfmerge},
+
+		{Name: "setbit", Arity: 4, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleSetbit},
+		{Name: "getbit", Arity: 3, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleGetbit},
+		{Name: "bitcount", Arity: -2, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleBitcount},
+		{Name: "bitpos", Arity: -3, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleBitpos},
+		{Name: "bitop", Arity: -4, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 2, LastKey: -1, Step: 1, Handler: handleBitop},
+		{Name: "bitfield", Arity: -2, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleBitfield},
+		{Name: "bitfield_ro", Arity: -2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleBitfield},
+
+		{Name: "xadd", Arity: -5, Flags: []string{flagWrite, flagDenyOOM, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXadd},
+		{Name: "xrange", Arity: -4, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXrange},
+		{Name: "xrevrange", Arity: -4, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXrange},
+		{Name: "xread", Arity: -4, Flags: []string{flagReadonly, flagBlocking, flagMovableKeys}, GetKeys: xreadKeys, Handler: handleXread},
+		{Name: "xlen", Arity: 2, Flags: []string{flagReadonly, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXlen},
+		{Name: "xdel", Arity: -3, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXdel},
+		{Name: "xtrim", Arity: -4, Flags: []string{flagWrite}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXtrim},
+		{Name: "xinfo", Arity: -2, Flags: []string{flagReadonly}, FirstKey: 2, LastKey: 2, Step: 1, Handler: handleXinfo},
+		{Name: "xgroup", Arity: -2, Flags: []string{flagWrite, flagDenyOOM}, FirstKey: 2, LastKey: 2, Step: 1, Handler: handleXgroup},
+		{Name: "xreadgroup", Arity: -7, Flags: []string{flagWrite, flagBlocking, flagMovableKeys}, GetKeys: xreadKeys, Handler: handleXreadgroup},
+		{Name: "xack", Arity: -4, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXack},
+		{Name: "xclaim", Arity: -6, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXclaim},
+		{Name: "xautoclaim", Arity: -6, Flags: []string{flagWrite, flagFast}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXautoclaim},
+		{Name: "xpending", Arity: -3, Flags: []string{flagReadonly}, FirstKey: 1, LastKey: 1, Step: 1, Handler: handleXpending},
+
+		{Name: "multi", Arity: 1, Flags: []string{flagNoScript, flagFast}, Handler: handleMulti},
+		{Name: "exec", Arity: 1, Flags: []string{flagNoScript}, Handler: handleExec},
+		{Name: "discard", Arity: 1, Flags: []string{flagNoScript, flagFast}, Handler: handleDiscard},
+		{Name: "watch", Arity: -2, Flags: []string{flagNoScript, flagFast}, FirstKey: 1, LastKey: -1, Step: 1, Handler: handleWatch},
+		{Name: "unwatch", Arity: 1, Flags: []string{flagNoScript, flagFast}, Handler: handleUnwatch},
+	} {
+		commandTable[cmd.Name] = cmd
+	}
+}
+
+func lookupCommand(name string) *Command {
+	return commandTable[strings.ToLower(name)]
+}
+
+func (cmd *Command) HasFlag(flag string) bool {
+	return slices.Contains(cmd.Flags, flag)
+}
+
+func (cmd *Command) CheckArity(argc int) bool {
+	if cmd.Arity >= 0 {
+		return argc == cmd.Arity
+	}
+	return argc >= -cmd.Arity
+}
+
+// Keys returns the key arguments of a call to cmd.
+func (cmd *Command) Keys(args []string) []string {
+	if cmd.GetKeys != nil {
+		return cmd.GetKeys(args)
+	}
+	if cmd.FirstKey == 0 {
+		return nil
+	}
+	last := cmd.LastKey
+	if last < 0 {
+		last += len(args)
+	}
+	var keys []string
+	for i := cmd.FirstKey; i <= last && i < len(args); i += cmd.Step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+func unknownCommandError(args []string) string {
+	var rest strings.Builder
+	for _, arg := range args[1:] {
+		fmt.Fprintf(&rest, "'%s' ", arg)
+	}
+	return fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", args[0], rest.String())
+}
+
+func arityError(name string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+func handleCommand(c *Client, args []string) error {
+	if len(args) == 1 {
+		names := make([]string, 0, len(commandTable))
+		for name := range commandTable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if err := respArrayLen(c, len(names)); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := respCommandInfo(c, commandTable[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch strings.ToUpper(args[1]) {
+	case "COUNT":
+		if len(args) != 2 {
+			return respWriter(c, ERROR, arityError("command|count"))
+		}
+		return respWriter(c, INTEGER, strconv.Itoa(len(commandTable)))
+	case "INFO":
+		names := args[2:]
+		if len(names) == 0 {
+			for name := range commandTable {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		if err := respArrayLen(c, len(names)); err != nil {
+			return err
+		}
+		for _, name := range names {
+			cmd := lookupCommand(name)
+			if cmd == nil {
+				if _, err := c.Write([]byte("*-1\r\n")); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := respCommandInfo(c, cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "GETKEYS":
+		if len(args) < 3 {
+			return respWriter(c, ERROR, arityError("command|getkeys"))
+		}
+		cmd := lookupCommand(args[2])
+		if cmd == nil {
+			return respWriter(c, ERROR, "ERR Invalid command specified")
+		}
+		if !cmd.CheckArity(len(args) - 2) {
+			return respWriter(c, ERROR, "ERR Invalid number of arguments specified for command")
+		}
+		keys := cmd.Keys(args[2:])
+		if len(keys) == 0 {
+			return respWriter(c, ERROR, "ERR The command has no key arguments")
+		}
+		return respArray(c, keys)
+	default:
+		return respWriter(c, ERROR, fmt.Sprintf("ERR unknown subcommand '%s'. Try COMMAND HELP.", args[1]))
+	}
+}
+
+// respCommandInfo writes the COMMAND INFO entry of cmd: name, arity, flags,
+// first key, last key, step, ACL categories, tips, key specs and
+// subcommands. The last four are not tracked and are sent empty.
+func respCommandInfo(c *Client, cmd *Command) error {
+	flags := cmd.Flags
+	if cmd.GetKeys != nil && !cmd.HasFlag(flagMovableKeys) {
+		flags = append(slices.Clone(flags), flagMovableKeys)
+	}
+	msg := fmt.Sprintf("*10\r\n$%d\r\n%s\r\n:%d\r\n*%d\r\n", len(cmd.Name), cmd.Name, cmd.Arity, len(flags))
+	for _, flag := range flags {
+		msg += fmt.Sprintf("+%s\r\n", flag)
+	}
+	msg += fmt.Sprintf(":%d\r\n:%d\r\n:%d\r\n", cmd.FirstKey, cmd.LastKey, cmd.Step)
+	msg += "*0\r\n*0\r\n*0\r\n*0\r\n"
+	_, err := c.Write([]byte(msg))
+	return err
+}
+
+// xreadKeys returns the stream names of an XREAD or XREADGROUP call: the
+// first half of the arguments following STREAMS.
+func xreadKeys(args []string) []string {
+	for i := 1; i < len(args); i++ {
+		if strings.ToUpper(args[i]) == "STREAMS" {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+// lmpopKeys returns the keys of an LMPOP or BLMPOP call: numkeys arguments
+// following numkeys, which BLMPOP precedes with a timeout.
+func lmpopKeys(args []string) []string {
+	i := 1
+	if strings.EqualFold(args[0], "blmpop") {
+		i++
+	}
+	numkeys, err := strconv.Atoi(args[i])
+	if err != nil || numkeys <= 0 || numkeys > len(args)-i-1 {
+		return nil
+	}
+	return args[i+1 : i+1+numkeys]
+}
+
+// sintercardKeys returns the keys of a SINTERCARD call: numkeys arguments
+// following numkeys.
+func sintercardKeys(args []string) []string {
+	numkeys, err := strconv.Atoi(args[1])
+	if err != nil || numkeys <= 0 || numkeys > len(args)-2 {
+		return nil
+	}
+	return args[2 : 2+numkeys]
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"time"
+)
+
+// Active expiration. Expired keys are deleted lazily when they are accessed,
+// but keys that are never read again would stay in memory forever. Like
+// Redis, a background cycle regularly samples keys that have a TTL and
+// deletes the expired ones, sampling again while a large share of the sample
+// turns out to be expired, within a fixed time budget per tick.
+const (
+	activeExpireInterval        = 100 * time.Millisecond
+	activeExpireTimeBudget      = activeExpireInterval / 4
+	activeExpireKeysPerLoop     = 20
+	activeExpireAcceptableStale = 10 // percent of a sample
+)
+
+// ExpireStats are the counters reported by INFO.
+type ExpireStats struct {
+	ExpiredKeys           int64
+	ExpiredStalePerc      float64
+	ExpiredTimeCapReached int64
+}
+
+// StartActiveExpire runs the active expiration cycle in the background until
+// the returned function is called, which waits for a running cycle to end.
+func (s *Store) StartActiveExpire() (stop func()) {
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(activeExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+			s.mu.Lock()
+			s.activeExpireCycle()
+			s.ServeReadyKeys()
+			s.mu.Unlock()
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+func (s *Store) activeExpireCycle() {
+	start := time.Now()
+	sampled, expired := 0, 0
+	for iteration := 0; ; iteration++ {
+		if iteration%16 == 15 && time.Since(start) > activeExpireTimeBudget {
+			s.stats.ExpiredTimeCapReached++
+			break
+		}
+		loopSampled, loopExpired := 0, 0
+		now := time.Now()
+		// Map iteration starts at a random position, which makes the first
+		// keys returned a cheap random sample.
+		for key, expiresAt := range s.expires {
+			if loopSampled == activeExpireKeysPerLoop {
+				break
+			}
+			loopSampled++
+			if now.After(expiresAt) {
+				s.expire(key)
+				loopExpired++
+			}
+		}
+		sampled += loopSampled
+		expired += loopExpired
+		if loopSampled == 0 || loopExpired*100/loopSampled <= activeExpireAcceptableStale {
+			break
+		}
+	}
+	// expired_stale_perc is a running average of the share of expired keys
+	// found in the samples, an estimate of how much memory is held by keys
+	// that are logically gone.
+	current := 0.0
+	if sampled > 0 {
+		current = float64(expired) / float64(sampled)
+	}
+	s.stats.ExpiredStalePerc = current*0.05 + s.stats.ExpiredStalePerc*0.95
+}
+
+// expire deletes a key whose TTL has passed.
+func (s *Store) expire(key string) {
+	s.Delete(key)
+	s.stats.ExpiredKeys++
+}
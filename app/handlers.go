@@ -1,341 +1,236 @@
 package main
 
 import (
-	"net"
+	"fmt"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 )
 
-type XRangeSerialized struct {
-	id     string
-	fields []string
-}
-
-type XReadSerialized struct {
-	stream  []string
-	entries [][]XRangeSerialized
+func handleMulti(c *Client, args []string) error {
+	if c.inMulti {
+		return respWriter(c, ERROR, "ERR MULTI calls can not be nested")
+	}
+	c.inMulti = true
+	return respWriter(c, SIMPLE, "OK")
 }
 
-func handleMulti(conn net.Conn) error {
-	return respWriter(conn, SIMPLE, "OK")
+func queueCommand(c *Client, args []string) error {
+	c.queued = append(c.queued, args)
+	return respWriter(c, SIMPLE, "QUEUED")
 }
 
-func handleIncr(conn net.Conn, key string) error {
-	n, ok := GlobalStore.Get(key)
-	if !ok {
-		GlobalStore.Set(key, StoreValue{
-			value:     "1",
-			expiresAt: time.Now().Add(24 * time.Hour),
-		})
-		return respWriter(conn, INTEGER, "1")
-	} else {
-		num, err := strconv.Atoi(n.value)
-		if err != nil {
-			return respWriter(conn, ERROR, "ERR value is not an integer or out of range")
-		}
-		GlobalStore.Set(key, StoreValue{value: strconv.Itoa(num + 1), expiresAt: n.expiresAt})
-		return respWriter(conn, INTEGER, strconv.Itoa(num+1))
+func handleExec(c *Client, args []string) error {
+	if !c.inMulti {
+		return respWriter(c, ERROR, "ERR EXEC without MULTI")
 	}
-}
-
-func handleXread(conn net.Conn, n int, params []string) error {
-	var ans XReadSerialized
-	var streams []string
-	var ids []string
-	for i := 0; i < len(params)/2; i++ {
-		streams = append(streams, params[i])
-		if params[i+len(params)/2] == "$" {
-			x := GlobalStore.streams[streams[i]]
-			y := x[len(x)-1].ID
-			ids = append(ids, y)
-		} else {
-			ids = append(ids, params[i+len(params)/2])
-		}
+	queued, aborted := c.queued, c.multiErr
+	c.discardTransaction()
+	changed := c.watchedKeysChanged()
+	c.unwatchAll()
+	if aborted {
+		return respWriter(c, ERROR, "EXECABORT Transaction discarded because of previous errors.")
 	}
-	var lmao [][]XRangeSerialized
-	added := false
-	for i := 0; i < len(streams); i++ {
-		var data []XRangeSerialized
-		stream := streams[i]
-		id := ids[i]
-		entries := GlobalStore.XRead(stream, id)
-		for _, entry := range entries {
-			element := XRangeSerialized{}
-			element.id = entry.ID
-			for key, value := range entry.Fields {
-				element.fields = append(element.fields, key)
-				element.fields = append(element.fields, value)
-				added = true
-			}
-			data = append(data, element)
-		}
-		lmao = append(lmao, data)
+	if changed {
+		_, err := c.Write([]byte("*-1\r\n"))
+		return err
 	}
-	if added {
-		ans.stream = streams
-		ans.entries = lmao
-		return respAny(conn, ans)
+	c.inExec = true
+	defer func() { c.inExec = false }()
+	if err := respArrayLen(c, len(queued)); err != nil {
+		return err
 	}
-	if n != 0 {
-		lmao = [][]XRangeSerialized{}
-		if n > 0 {
-			time.Sleep(time.Duration(n * int(time.Millisecond)))
-		}
-		for i := 0; i < len(streams); i++ {
-			var data []XRangeSerialized
-			stream := streams[i]
-			id := ids[i]
-			entries := GlobalStore.XRead(stream, id)
-			for _, entry := range entries {
-				element := XRangeSerialized{}
-				element.id = entry.ID
-				for key, value := range entry.Fields {
-					element.fields = append(element.fields, key)
-					element.fields = append(element.fields, value)
-					added = true
-				}
-				data = append(data, element)
-			}
-			lmao = append(lmao, data)
-		}
-		if !added {
-			if _, err := conn.Write([]byte("*-1\r\n")); err != nil {
-				return err
-			}
-			return nil
-		}
-		ans.stream = streams
-		ans.entries = lmao
-		return respAny(conn, ans)
-	} else {
-		for {
-			lmao = [][]XRangeSerialized{}
-			time.Sleep(time.Duration(10 * int(time.Millisecond)))
-			for i := 0; i < len(streams); i++ {
-				var data []XRangeSerialized
-				stream := streams[i]
-				id := ids[i]
-				entries := GlobalStore.XRead(stream, id)
-				for _, entry := range entries {
-					element := XRangeSerialized{}
-					element.id = entry.ID
-					for key, value := range entry.Fields {
-						element.fields = append(element.fields, key)
-						element.fields = append(element.fields, value)
-						added = true
-					}
-					data = append(data, element)
-				}
-				lmao = append(lmao, data)
-			}
-			if added {
-				break
-			}
+	for _, args := range queued {
+		if err := executeCommand(c, args); err != nil {
+			return err
 		}
-		ans.stream = streams
-		ans.entries = lmao
-		return respAny(conn, ans)
 	}
+	return nil
 }
 
-func handleXrange(conn net.Conn, stream, start, end string) error {
-	entries := GlobalStore.XRange(stream, start, end)
-	var data []XRangeSerialized
-	for _, entry := range entries {
-		element := XRangeSerialized{}
-		element.id = entry.ID
-		for key, value := range entry.Fields {
-			element.fields = append(element.fields, key)
-			element.fields = append(element.fields, value)
-		}
-		data = append(data, element)
+func handleDiscard(c *Client, args []string) error {
+	if !c.inMulti {
+		return respWriter(c, ERROR, "ERR DISCARD without MULTI")
 	}
-	return respAny(conn, data)
+	c.discardTransaction()
+	c.unwatchAll()
+	return respWriter(c, SIMPLE, "OK")
 }
 
-func handleXadd(conn net.Conn, stream string, id string, args []string) error {
-	if err := verifyId(stream, id); err != nil {
-		return respWriter(conn, ERROR, err.Error())
-	}
-	id, err := completeId(stream, id)
-	if err != nil {
-		return err
+func handleWatch(c *Client, args []string) error {
+	if c.inMulti {
+		return respWriter(c, ERROR, "ERR WATCH inside MULTI is not allowed")
 	}
-	tmp := make(map[string]string)
-	for i := 0; i < len(args); i += 2 {
-		tmp[args[i]] = args[i+1]
+	for _, key := range args[1:] {
+		c.watch(key)
 	}
-	GlobalStore.XAdd(stream, id, tmp)
-	return respWriter(conn, BULK, id)
+	return respWriter(c, SIMPLE, "OK")
 }
 
-func handleType(conn net.Conn, key string) error {
-	_, ok := GlobalStore.Get(key)
-	if ok {
-		return respWriter(conn, SIMPLE, "string")
-	}
-	_, ok = GlobalStore.streams[key]
-	if ok {
-		return respWriter(conn, SIMPLE, "stream")
-	}
-	return respWriter(conn, BULK, "none")
+func handleUnwatch(c *Client, args []string) error {
+	c.unwatchAll()
+	return respWriter(c, SIMPLE, "OK")
 }
 
-func handleBlpop(conn net.Conn, key, wait string) error {
-	waitTime, err := strconv.ParseFloat(wait, 64)
+func handleIncr(c *Client, args []string) error {
+	key := args[1]
+	n, ok, err := GlobalStore.Get(key)
 	if err != nil {
-		return err
-	}
-	if len(GlobalStore.lists[key]) > 0 {
-		val := GlobalStore.LPop(key)
-		return respArray(conn, []string{key, val})
-	}
-	if waitTime == 0 {
-		ch := make(chan string, 1)
-		defer close(ch)
-		GlobalStore.blockedChannels[key] = append(GlobalStore.blockedChannels[key], ch)
-		val := <-ch
-		return respArray(conn, []string{key, val})
+		return respWriter(c, ERROR, err.Error())
 	}
-	ch := make(chan string, 1)
-	defer close(ch)
-	GlobalStore.blockedChannels[key] = append(GlobalStore.blockedChannels[key], ch)
-	time.Sleep(time.Duration(waitTime * float64(time.Second)))
-	select {
-	case val := <-ch:
-		return respArray(conn, []string{key, val})
-	default:
-		mutex := GlobalStore.GetListMutex(key)
-		mutex.Lock()
-		defer mutex.Unlock()
-		chans := GlobalStore.blockedChannels[key]
-		for i, c := range chans {
-			if c == ch {
-				GlobalStore.blockedChannels[key] = append(chans[:i], chans[i+1:]...)
-				break
-			}
+	if !ok {
+		GlobalStore.Set(key, "1", time.Time{})
+		return respWriter(c, INTEGER, "1")
+	} else {
+		num, err := strconv.Atoi(n)
+		if err != nil {
+			return respWriter(c, ERROR, "ERR value is not an integer or out of range")
 		}
-		_, err := conn.Write([]byte("*-1\r\n"))
-		return err
+		GlobalStore.Set(key, strconv.Itoa(num+1), GlobalStore.ExpiresAt(key))
+		return respWriter(c, INTEGER, strconv.Itoa(num+1))
 	}
 }
 
-func handleLRange(conn net.Conn, key, l, r string) error {
-	left, err := strconv.Atoi(l)
-	if err != nil {
-		return err
-	}
-	right, err := strconv.Atoi(r)
-	if err != nil {
-		return err
-	}
-	n := len(GlobalStore.lists[key])
-	if left < 0 {
-		left += n
-	}
-	left = max(left, 0)
-	for right < 0 {
-		right += n
-	}
-	right = min(right, n-1)
-	elem := GlobalStore.LRange(key, left, right)
-	return respArray(conn, elem)
-}
-
-func handleRpush(conn net.Conn, key string, value []string) error {
-	GlobalStore.Rpush(key, value)
-	length := len(GlobalStore.lists[key])
-	for _, channel := range GlobalStore.blockedChannels[key] {
-		if len(value) == 0 {
-			break
-		}
-		channel <- value[0]
-		value = value[1:]
-		GlobalStore.blockedChannels[key] = GlobalStore.blockedChannels[key][1:]
-	}
-	return respWriter(conn, INTEGER, strconv.Itoa(length))
+func handleType(c *Client, args []string) error {
+	return respWriter(c, SIMPLE, GlobalStore.Type(args[1]))
 }
 
-func handleLPush(conn net.Conn, key string, value []string) error {
-	GlobalStore.Lpush(key, value)
-	length := len(GlobalStore.lists[key])
-	for _, channel := range GlobalStore.blockedChannels[key] {
-		if len(value) == 0 {
-			break
-		}
-		// last element was first inserted in case of Lpush
-		channel <- value[len(value)-1]
-		value = value[:len(value)-1]
-		GlobalStore.blockedChannels[key] = GlobalStore.blockedChannels[key][1:]
+func handleGet(c *Client, args []string) error {
+	key := args[1]
+	val, ok, err := GlobalStore.Get(key)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
 	}
-	return respWriter(conn, INTEGER, strconv.Itoa(length))
-}
-
-func handleLlen(conn net.Conn, key string) error {
-	length := len(GlobalStore.lists[key])
-	return respWriter(conn, INTEGER, strconv.Itoa(length))
-}
-
-func handleLpop(conn net.Conn, key string) error {
-	if len(GlobalStore.lists[key]) == 0 {
-		if _, err := conn.Write([]byte("$-1\r\n")); err != nil {
+	if ok {
+		return respWriter(c, BULK, val)
+	} else {
+		if _, err := c.Write([]byte("$-1\r\n")); err != nil {
 			return err
 		}
 		return nil
-	} else {
-		val := GlobalStore.LPop(key)
-		return respWriter(conn, BULK, val)
 	}
 }
 
-func handleLpopMultiple(conn net.Conn, key string, n string) error {
-	num, err := strconv.Atoi(n)
-	if err != nil {
-		return err
-	}
-	if len(GlobalStore.lists[key]) == 0 {
-		if _, err := conn.Write([]byte("$-1\r\n")); err != nil {
-			return err
+// handleSet implements SET key value [NX | XX] [GET] [EX seconds |
+// PX milliseconds | EXAT unix-seconds | PXAT unix-milliseconds | KEEPTTL].
+func handleSet(c *Client, args []string) error {
+	key, value := args[1], args[2]
+	var nx, xx, get, keepTTL bool
+	expireOpt := ""
+	var expiresAt time.Time
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch opt {
+		case "NX", "XX":
+			if nx || xx {
+				return respWriter(c, ERROR, "ERR syntax error")
+			}
+			nx, xx = opt == "NX", opt == "XX"
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			if expireOpt != "" {
+				return respWriter(c, ERROR, "ERR syntax error")
+			}
+			keepTTL = true
+			expireOpt = opt
+		case "EX", "PX", "EXAT", "PXAT":
+			if expireOpt != "" || i+1 == len(args) {
+				return respWriter(c, ERROR, "ERR syntax error")
+			}
+			expireOpt = opt
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+			}
+			if n <= 0 || (opt[0] == 'E' && n > math.MaxInt64/1000) {
+				return respWriter(c, ERROR, "ERR invalid expire time in 'set' command")
+			}
+			switch opt {
+			case "EX":
+				expiresAt = time.Now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				expiresAt = time.Now().Add(time.Duration(n) * time.Millisecond)
+			case "EXAT":
+				expiresAt = time.Unix(n, 0)
+			case "PXAT":
+				expiresAt = time.UnixMilli(n)
+			}
+		default:
+			return respWriter(c, ERROR, "ERR syntax error")
 		}
-		return nil
-	} else if len(GlobalStore.lists[key]) <= num {
-		return respArray(conn, GlobalStore.lists[key])
-	} else {
-		values := GlobalStore.LPopMultiple(key, num)
-		return respArray(conn, values)
 	}
-}
 
-func handleGet(conn net.Conn, key string) error {
-	val, ok := GlobalStore.Get(key)
-	if ok {
-		if time.Now().After(val.expiresAt) {
-			delete(GlobalStore.data, key)
-			if _, err := conn.Write([]byte("$-1\r\n")); err != nil {
-				return err
-			}
-			return nil
-		} else {
-			return respWriter(conn, BULK, val.value)
+	old, exists, err := GlobalStore.Get(key)
+	if get && err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	exists = exists || err != nil
+	if (nx && exists) || (xx && !exists) {
+		if get && exists {
+			return respWriter(c, BULK, old)
 		}
-	} else {
-		if _, err := conn.Write([]byte("$-1\r\n")); err != nil {
+		_, err := c.Write([]byte("$-1\r\n"))
+		return err
+	}
+	if keepTTL {
+		expiresAt = GlobalStore.ExpiresAt(key)
+	}
+	GlobalStore.Set(key, value, expiresAt)
+	// An absolute time in the past stores the key only to expire it.
+	if !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+		GlobalStore.expire(key)
+	}
+	if get {
+		if !exists {
+			_, err := c.Write([]byte("$-1\r\n"))
 			return err
 		}
-		return nil
+		return respWriter(c, BULK, old)
 	}
+	return respWriter(c, SIMPLE, "OK")
 }
 
-func handleSet(conn net.Conn, key, value string, expireDuration time.Duration) error {
-	expiresAt := time.Now().Add(expireDuration)
-	GlobalStore.Set(key, StoreValue{value: value, expiresAt: expiresAt})
-	return respWriter(conn, SIMPLE, "OK")
+func handleEcho(c *Client, args []string) error {
+	return respWriter(c, BULK, args[1])
 }
 
-func handleEcho(conn net.Conn, str string) error {
-	return respWriter(conn, BULK, str)
+// handleInfo reports server statistics. Only the stats and keyspace sections
+// are available.
+func handleInfo(c *Client, args []string) error {
+	sections := map[string]bool{}
+	for _, arg := range args[1:] {
+		sections[strings.ToLower(arg)] = true
+	}
+	all := len(sections) == 0 || sections["all"] || sections["default"] || sections["everything"]
+	var info strings.Builder
+	if all || sections["stats"] {
+		stats := GlobalStore.stats
+		info.WriteString("# Stats\r\n")
+		fmt.Fprintf(&info, "expired_keys:%d\r\n", stats.ExpiredKeys)
+		fmt.Fprintf(&info, "expired_stale_perc:%.2f\r\n", stats.ExpiredStalePerc*100)
+		fmt.Fprintf(&info, "expired_time_cap_reached_count:%d\r\n", stats.ExpiredTimeCapReached)
+	}
+	if all || sections["keyspace"] {
+		if info.Len() > 0 {
+			info.WriteString("\r\n")
+		}
+		info.WriteString("# Keyspace\r\n")
+		if keys, volatile := GlobalStore.KeyCount(); keys > 0 {
+			fmt.Fprintf(&info, "db0:keys=%d,expires=%d\r\n", keys, volatile)
+		}
+	}
+	return respWriter(c, BULK, info.String())
 }
 
-func handlePing(conn net.Conn) error {
-	return respWriter(conn, SIMPLE, "PONG")
+func handlePing(c *Client, args []string) error {
+	if len(args) > 2 {
+		return respWriter(c, ERROR, arityError(args[0]))
+	}
+	if len(args) == 2 {
+		return respWriter(c, BULK, args[1])
+	}
+	return respWriter(c, SIMPLE, "PONG")
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"maps"
+	"math/rand"
+)
+
+// hashValue is the value of a hash: a map from field to value plus the fields
+// in scan order, which HSCAN seeks in and which HKEYS and friends list them
+// in.
+type hashValue struct {
+	dict  map[string]string
+	order *scanOrder
+}
+
+func newHashValue() *hashValue {
+	return &hashValue{dict: make(map[string]string), order: newScanOrder()}
+}
+
+// Len returns the number of fields. A nil hash is empty.
+func (h *hashValue) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.dict)
+}
+
+func (h *hashValue) Get(field string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	val, ok := h.dict[field]
+	return val, ok
+}
+
+// Set sets field to value and reports whether the field is new.
+func (h *hashValue) Set(field, value string) bool {
+	_, ok := h.dict[field]
+	if !ok {
+		h.order.Add(field)
+	}
+	h.dict[field] = value
+	return !ok
+}
+
+// Delete removes field and reports whether it existed.
+func (h *hashValue) Delete(field string) bool {
+	if _, ok := h.dict[field]; !ok {
+		return false
+	}
+	h.order.Remove(field)
+	delete(h.dict, field)
+	return true
+}
+
+// Fields returns the fields in scan order, which stays the same while the
+// hash is not modified.
+func (h *hashValue) Fields() []string {
+	if h == nil {
+		return []string{}
+	}
+	return h.order.Members()
+}
+
+// Random returns a field picked at random from a hash that is not empty.
+func (h *hashValue) Random() string {
+	return h.order.At(rand.Intn(len(h.dict)))
+}
+
+// Sample returns count distinct fields picked at random, or all of them if
+// the hash has no more than count.
+func (h *hashValue) Sample(count int) []string {
+	if count >= h.Len() {
+		return h.Fields()
+	}
+	return randomSample(h.Len(), count, h.order.At, h.order.Members)
+}
+
+func (h *hashValue) Clone() *hashValue {
+	c := &hashValue{dict: maps.Clone(h.dict), order: newScanOrder()}
+	for field := range h.dict {
+		c.order.Add(field)
+	}
+	return c
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// respFieldValues writes fields, each followed by its value if withValues is
+// set.
+func respFieldValues(c *Client, hash *hashValue, fields []string, withValues bool) error {
+	reply := fields
+	if withValues {
+		reply = make([]string, 0, 2*len(fields))
+		for _, field := range fields {
+			val, _ := hash.Get(field)
+			reply = append(reply, field, val)
+		}
+	}
+	return respArray(c, reply)
+}
+
+// handleHset implements HSET key field value [field value ...] and HMSET,
+// which replies OK instead of the number of fields added.
+func handleHset(c *Client, args []string) error {
+	if len(args)%2 != 0 {
+		return respWriter(c, ERROR, arityError(args[0]))
+	}
+	added, err := GlobalStore.HSet(args[1], args[2:])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if strings.EqualFold(args[0], "hmset") {
+		return respWriter(c, SIMPLE, "OK")
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(added))
+}
+
+func handleHsetnx(c *Client, args []string) error {
+	ok, err := GlobalStore.HSetNX(args[1], args[2], args[3])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if !ok {
+		return respWriter(c, INTEGER, "0")
+	}
+	return respWriter(c, INTEGER, "1")
+}
+
+func handleHget(c *Client, args []string) error {
+	val, ok, err := GlobalStore.HGet(args[1], args[2])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if !ok {
+		_, err := c.Write([]byte("$-1\r\n"))
+		return err
+	}
+	return respWriter(c, BULK, val)
+}
+
+func handleHmget(c *Client, args []string) error {
+	hash, err := GlobalStore.Hash(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	fields := args[2:]
+	if err := respArrayLen(c, len(fields)); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		val, ok := hash.Get(field)
+		if !ok {
+			if _, err := c.Write([]byte("$-1\r\n")); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := respWriter(c, BULK, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleHgetall implements HGETALL, HKEYS and HVALS.
+func handleHgetall(c *Client, args []string) error {
+	hash, err := GlobalStore.Hash(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	fields := hash.Fields()
+	switch strings.ToLower(args[0]) {
+	case "hkeys":
+		return respArray(c, fields)
+	case "hvals":
+		vals := make([]string, len(fields))
+		for i, field := range fields {
+			vals[i], _ = hash.Get(field)
+		}
+		return respArray(c, vals)
+	}
+	return respFieldValues(c, hash, fields, true)
+}
+
+func handleHdel(c *Client, args []string) error {
+	n, err := GlobalStore.HDel(args[1], args[2:])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+func handleHexists(c *Client, args []string) error {
+	_, ok, err := GlobalStore.HGet(args[1], args[2])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if !ok {
+		return respWriter(c, INTEGER, "0")
+	}
+	return respWriter(c, INTEGER, "1")
+}
+
+func handleHlen(c *Client, args []string) error {
+	hash, err := GlobalStore.Hash(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(hash.Len()))
+}
+
+func handleHstrlen(c *Client, args []string) error {
+	val, _, err := GlobalStore.HGet(args[1], args[2])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(len(val)))
+}
+
+func handleHincrby(c *Client, args []string) error {
+	incr, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	n, err := GlobalStore.HIncrBy(args[1], args[2], incr)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.FormatInt(n, 10))
+}
+
+func handleHincrbyfloat(c *Client, args []string) error {
+	incr, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not a valid float")
+	}
+	val, err := GlobalStore.HIncrByFloat(args[1], args[2], incr)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, BULK, val)
+}
+
+// maxRandomRepeats is the largest negative count of HRANDFIELD and
+// SRANDMEMBER, whose picks may repeat. The reply is built in memory while the
+// store is locked, so a larger count gets an error instead of a reply that
+// could exhaust memory.
+const maxRandomRepeats = 1 << 24
+
+// parseRandomCount parses the count of HRANDFIELD and SRANDMEMBER. Like
+// Redis it rejects counts whose negation, or with WITHVALUES the length of
+// whose reply, would overflow.
+func parseRandomCount(arg string, withValues bool) (int, error) {
+	count, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, errors.New("ERR value is not an integer or out of range")
+	}
+	if count == math.MinInt64 {
+		return 0, errors.New("ERR value is out of range, value must between -9223372036854775807 and 9223372036854775807")
+	}
+	if withValues && count < -math.MaxInt64/2 {
+		return 0, errors.New("ERR value is out of range")
+	}
+	return count, nil
+}
+
+// checkRandomRepeats returns an error if the negative count of HRANDFIELD or
+// SRANDMEMBER asks for more than maxRandomRepeats picks.
+func checkRandomRepeats(count int) error {
+	if -count > maxRandomRepeats {
+		return fmt.Errorf("ERR value is out of range, a negative count may not ask for more than %d elements", maxRandomRepeats)
+	}
+	return nil
+}
+
+// handleHrandfield implements HRANDFIELD key [count [WITHVALUES]]. A
+// positive count returns distinct fields, a negative one may repeat them.
+func handleHrandfield(c *Client, args []string) error {
+	if len(args) > 4 || (len(args) == 4 && !strings.EqualFold(args[3], "WITHVALUES")) {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	hash, err := GlobalStore.Hash(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if len(args) == 2 {
+		if hash.Len() == 0 {
+			_, err := c.Write([]byte("$-1\r\n"))
+			return err
+		}
+		return respWriter(c, BULK, hash.Random())
+	}
+	withValues := len(args) == 4
+	count, err := parseRandomCount(args[2], withValues)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if count >= 0 || hash.Len() == 0 {
+		return respFieldValues(c, hash, hash.Sample(max(count, 0)), withValues)
+	}
+	if err := checkRandomRepeats(count); err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	n := -count
+	if withValues {
+		n *= 2
+	}
+	if err := respArrayLen(c, n); err != nil {
+		return err
+	}
+	for range -count {
+		field := hash.Random()
+		if err := respWriter(c, BULK, field); err != nil {
+			return err
+		}
+		if withValues {
+			val, _ := hash.Get(field)
+			if err := respWriter(c, BULK, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleHscan implements HSCAN key cursor [MATCH pattern] [COUNT count]
+// [NOVALUES].
+func handleHscan(c *Client, args []string) error {
+	opts, err := parseScan(args[2:], true)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	hash, err := GlobalStore.Hash(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	var cursor uint64
+	var fields []string
+	if hash != nil {
+		cursor, fields = hash.order.Scan(opts)
+	}
+	reply := fields
+	if !opts.novalues {
+		reply = make([]string, 0, 2*len(fields))
+		for _, field := range fields {
+			val, _ := hash.Get(field)
+			reply = append(reply, field, val)
+		}
+	}
+	return respScan(c, cursor, reply)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func isError(reply any) bool {
+	_, ok := reply.(replyError)
+	return ok
+}
+
+func TestHrandfieldCount(t *testing.T) {
+	tc := newTestClient(t)
+	values := map[string]string{"a": "1", "b": "2", "c": "3"}
+	for f, v := range values {
+		if _, err := tc.Do("hset", "test:hrand", f, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { tc.Do("del", "test:hrand") })
+
+	// A negative count past what a single call could once return still gets
+	// as many picks as it asks for.
+	const n = 2_000_000
+	for _, withValues := range []bool{false, true} {
+		args := []string{"hrandfield", "test:hrand", strconv.Itoa(-n)}
+		want := n
+		if withValues {
+			args = append(args, "withvalues")
+			want *= 2
+		}
+		reply, err := tc.Do(args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		elems, _ := reply.([]any)
+		if len(elems) != want {
+			t.Fatalf("%v returned %d elements, want %d", args, len(elems), want)
+		}
+		for i := 0; i < len(elems); i++ {
+			f, _ := elems[i].(string)
+			if _, ok := values[f]; !ok {
+				t.Fatalf("%v returned %q", args, f)
+			}
+			if withValues {
+				if i++; elems[i] != values[f] {
+					t.Fatalf("%v returned %q for %q", args, elems[i], f)
+				}
+			}
+		}
+	}
+	if reply, _ := tc.Do("hrandfield", "test:hrand", strconv.Itoa(-maxRandomRepeats-1)); !isError(reply) {
+		t.Fatalf("count past maxRandomRepeats replied %v", reply)
+	}
+
+	for count := range 5 {
+		reply, err := tc.Do("hrandfield", "test:hrand", strconv.Itoa(count))
+		if err != nil {
+			t.Fatal(err)
+		}
+		elems, _ := reply.([]any)
+		seen := make(map[any]bool)
+		for _, f := range elems {
+			if _, ok := values[f.(string)]; !ok || seen[f] {
+				t.Fatalf("HRANDFIELD %d returned %v", count, elems)
+			}
+			seen[f] = true
+		}
+		if len(elems) != min(count, len(values)) {
+			t.Fatalf("HRANDFIELD %d returned %d fields", count, len(elems))
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// HyperLogLogs are strings in the format Redis uses, so GET and SET carry
+// them between servers unchanged. A 16 byte header ("HYLL", the encoding,
+// three unused bytes and the cached cardinality, little endian, whose most
+// significant bit marks it stale) is followed by 2^14 registers of 6 bits.
+// The dense encoding packs them all; the sparse one run-length encodes them
+// with the opcodes
+//
+//	00xxxxxx           ZERO:  xxxxxx+1 registers set to 0
+//	01xxxxxx yyyyyyyy  XZERO: xxxxxxyyyyyyyy+1 registers set to 0
+//	1vvvvvxx           VAL:   xx+1 registers set to vvvvv+1
+//
+// and is used while it stays small. Once a register exceeds what VAL can
+// hold or the string would grow past hllSparseMaxBytes it turns dense.
+const (
+	hllP              = 14
+	hllQ              = 64 - hllP
+	hllRegisters      = 1 << hllP
+	hllBits           = 6
+	hllHeaderSize     = 16
+	hllDenseSize      = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllDense          = 0
+	hllSparse         = 1
+	hllSparseValMax   = 32
+	hllSparseMaxBytes = 3000
+	hllAlphaInf       = 0.721347520444481703680
+)
+
+var (
+	errNotHLL     = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	errCorruptHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// newHLL returns an empty sparse HyperLogLog.
+func newHLL() []byte {
+	b := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(b, "HYLL")
+	b[4] = hllSparse
+	return append(b, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+// isHLL reports whether b looks like a HyperLogLog. A sparse one may still
+// turn out to be corrupted when decoded.
+func isHLL(b []byte) bool {
+	if len(b) < hllHeaderSize || string(b[:4]) != "HYLL" {
+		return false
+	}
+	switch b[4] {
+	case hllDense:
+		return len(b) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+// hllHash returns the register an element falls into and the length of the
+// run of zeros, plus one, in the rest of its hash.
+func hllHash(elem string) (int, uint8) {
+	hash := murmurHash64A([]byte(elem), 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// murmurHash64A is MurmurHash2, 64-bit version, as Redis has it.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(data)) * m)
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+func hllDenseGet(regs []byte, i int) uint8 {
+	byteIdx, fb := i*hllBits/8, uint(i*hllBits&7)
+	v := regs[byteIdx] >> fb
+	if byteIdx+1 < len(regs) {
+		v |= regs[byteIdx+1] << (8 - fb)
+	}
+	return v & 63
+}
+
+func hllDenseSet(regs []byte, i int, v uint8) {
+	byteIdx, fb := i*hllBits/8, uint(i*hllBits&7)
+	regs[byteIdx] &^= 63 << fb
+	regs[byteIdx] |= v << fb
+	if byteIdx+1 < len(regs) {
+		regs[byteIdx+1] &^= 63 >> (8 - fb)
+		regs[byteIdx+1] |= v >> (8 - fb)
+	}
+}
+
+// hllDecode returns the registers of the HyperLogLog b.
+func hllDecode(b []byte) ([]uint8, error) {
+	regs := make([]uint8, hllRegisters)
+	if b[4] == hllDense {
+		for i := range regs {
+			regs[i] = hllDenseGet(b[hllHeaderSize:], i)
+		}
+		return regs, nil
+	}
+	i := 0
+	for p := hllHeaderSize; p < len(b); p++ {
+		op := b[p]
+		var n int
+		var v uint8
+		switch {
+		case op&0xc0 == 0x00:
+			n = int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if p+1 == len(b) {
+				return nil, errCorruptHLL
+			}
+			p++
+			n = (int(op&0x3f)<<8 | int(b[p])) + 1
+		default:
+			n, v = int(op&0x3)+1, (op>>2)&0x1f+1
+		}
+		if i+n > hllRegisters {
+			return nil, errCorruptHLL
+		}
+		for ; n > 0; n-- {
+			regs[i] = v
+			i++
+		}
+	}
+	if i != hllRegisters {
+		return nil, errCorruptHLL
+	}
+	return regs, nil
+}
+
+// hllEncode encodes regs behind the header of b, sparse if sparse is set and
+// the registers allow it, dense otherwise. The cached cardinality is marked
+// stale.
+func hllEncode(b []byte, regs []uint8, sparse bool) []byte {
+	out := make([]byte, hllHeaderSize, hllDenseSize)
+	copy(out, b[:hllHeaderSize])
+	out[15] |= 0x80
+	if sparse {
+		if enc, ok := hllEncodeSparse(out, regs); ok {
+			return enc
+		}
+	}
+	out[4] = hllDense
+	out = out[:hllDenseSize]
+	clear(out[hllHeaderSize:])
+	for i, v := range regs {
+		hllDenseSet(out[hllHeaderSize:], i, v)
+	}
+	return out
+}
+
+func hllEncodeSparse(out []byte, regs []uint8) ([]byte, bool) {
+	out[4] = hllSparse
+	for i := 0; i < len(regs); {
+		v := regs[i]
+		if v > hllSparseValMax {
+			return nil, false
+		}
+		run := 1
+		for i+run < len(regs) && regs[i+run] == v {
+			run++
+		}
+		i += run
+		for run > 0 {
+			var n int
+			switch {
+			case v > 0:
+				n = min(run, 4)
+				out = append(out, 0x80|(v-1)<<2|byte(n-1))
+			case run > 64:
+				n = min(run, hllRegisters)
+				out = append(out, 0x40|byte((n-1)>>8), byte((n-1)&0xff))
+			default:
+				n = run
+				out = append(out, byte(n-1))
+			}
+			run -= n
+		}
+		if len(out) > hllSparseMaxBytes {
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// hllAdd adds elems to the HyperLogLog b and reports whether any register
+// changed. b is modified in place if it is dense.
+func hllAdd(b []byte, elems []string) ([]byte, bool, error) {
+	changed := false
+	if b[4] == hllDense {
+		for _, elem := range elems {
+			i, count := hllHash(elem)
+			if count > hllDenseGet(b[hllHeaderSize:], i) {
+				hllDenseSet(b[hllHeaderSize:], i, count)
+				changed = true
+			}
+		}
+		if changed {
+			b[15] |= 0x80
+		}
+		return b, changed, nil
+	}
+	regs, err := hllDecode(b)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, elem := range elems {
+		i, count := hllHash(elem)
+		if count > regs[i] {
+			regs[i] = count
+			changed = true
+		}
+	}
+	if !changed {
+		return b, false, nil
+	}
+	return hllEncode(b, regs, true), true, nil
+}
+
+// hllCachedCount returns the cardinality cached in the header of b, if it is
+// not stale.
+func hllCachedCount(b []byte) (uint64, bool) {
+	if b[15]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(b[8:16]), true
+}
+
+func hllSetCachedCount(b []byte, card uint64) {
+	binary.LittleEndian.PutUint64(b[8:16], card)
+}
+
+// hllCount estimates the cardinality from the registers with the estimator
+// of Otmar Ertl, "New cardinality estimation algorithms for HyperLogLog
+// sketches", as Redis does.
+func hllCount(regs []uint8) uint64 {
+	var histo [64]int
+	for _, v := range regs {
+		histo[v]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
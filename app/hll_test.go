@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// addRange adds the elements prefix0 to prefix(n-1) to the HyperLogLog at key
+// in batches.
+func addRange(t *testing.T, s *Store, key, prefix string, n int) {
+	t.Helper()
+	batch := make([]string, 0, 1000)
+	for i := 0; i < n; i++ {
+		batch = append(batch, prefix+strconv.Itoa(i))
+		if len(batch) == cap(batch) || i == n-1 {
+			if _, err := s.PFAdd(key, batch); err != nil {
+				t.Fatal(err)
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+func hllBytes(t *testing.T, s *Store, key string) []byte {
+	t.Helper()
+	b, err := s.hll(key)
+	if err != nil || b == nil {
+		t.Fatalf("hll %s: %v", key, err)
+	}
+	return b
+}
+
+func TestHLLAccuracy(t *testing.T) {
+	// The standard error with 2^14 registers is 0.81%; allow four times it.
+	for _, n := range []int{100, 1000, 10000, 100000, 1000000} {
+		if n == 1000000 && testing.Short() {
+			continue
+		}
+		s := NewStore()
+		addRange(t, s, "hll", "elem:", n)
+		card, err := s.PFCount([]string{"hll"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rel := math.Abs(float64(card)-float64(n)) / float64(n); rel > 4*0.0081 {
+			t.Errorf("PFCOUNT of %d elements = %d, off by %.2f%%", n, card, 100*rel)
+		}
+		// The count is cached and adding known elements keeps it.
+		if cached, ok := hllCachedCount(hllBytes(t, s, "hll")); !ok || cached != card {
+			t.Errorf("cached count of %d elements = %d, %v", n, cached, ok)
+		}
+		if changed, _ := s.PFAdd("hll", []string{"elem:0"}); changed {
+			t.Errorf("re-adding an element of %d changed the HyperLogLog", n)
+		}
+	}
+}
+
+func TestHLLEmptyLayout(t *testing.T) {
+	// An empty HyperLogLog as Redis creates it: sparse, a valid cached count
+	// of 0 and a single XZERO opcode covering all 16384 registers.
+	want := []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff")
+	if got := newHLL(); !bytes.Equal(got, want) {
+		t.Fatalf("newHLL() = %q, want %q", got, want)
+	}
+}
+
+func TestHLLSparseLayout(t *testing.T) {
+	regs := make([]uint8, hllRegisters)
+	regs[100], regs[101] = 3, 3
+	regs[150] = 32
+	got := hllEncode(newHLL(), regs, true)
+	want := []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80")
+	want = append(want,
+		0x40, 99, // XZERO: registers 0-99
+		0x80|2<<2|1, // VAL: registers 100-101 set to 3
+		47,          // ZERO: registers 102-149
+		0x80|31<<2,  // VAL: register 150 set to 32
+		0x7f, 0x68,  // XZERO: the 16233 registers left
+	)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("sparse encoding = %x, want %x", got, want)
+	}
+	decoded, err := hllDecode(got)
+	if err != nil || !slices.Equal(decoded, regs) {
+		t.Fatalf("decoding the sparse encoding failed: %v", err)
+	}
+	// VAL holds at most 32, so a larger register makes it dense.
+	regs[300] = 33
+	if got := hllEncode(newHLL(), regs, true); got[4] != hllDense || len(got) != hllDenseSize {
+		t.Fatalf("register of 33 encoded with encoding %d, %d bytes", got[4], len(got))
+	}
+}
+
+func TestHLLDenseLayout(t *testing.T) {
+	// Registers are packed little endian: register 0 takes the low 6 bits
+	// of byte 0, register 1 its top 2 bits and the low 4 bits of byte 1.
+	regs := make([]uint8, hllRegisters)
+	regs[1] = 0b101011
+	regs[hllRegisters-1] = 63
+	b := hllEncode(newHLL(), regs, false)
+	if len(b) != hllDenseSize || b[4] != hllDense {
+		t.Fatalf("dense encoding has encoding %d, %d bytes", b[4], len(b))
+	}
+	data := b[hllHeaderSize:]
+	if data[0] != 0xc0 || data[1] != 0x0a || data[len(data)-1] != 0xfc {
+		t.Fatalf("dense registers start %x and end %x", data[:2], data[len(data)-1])
+	}
+	decoded, err := hllDecode(b)
+	if err != nil || !slices.Equal(decoded, regs) {
+		t.Fatalf("decoding the dense encoding failed: %v", err)
+	}
+	hllSetCachedCount(b, 0x0102030405060708)
+	if !bytes.Equal(b[8:16], []byte{8, 7, 6, 5, 4, 3, 2, 1}) {
+		t.Fatalf("cached count stored as %x", b[8:16])
+	}
+}
+
+func TestHLLSparseToDense(t *testing.T) {
+	s := NewStore()
+	var regs []uint8
+	for i := 0; ; i++ {
+		if _, err := s.PFAdd("hll", []string{"elem:" + strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+		b := hllBytes(t, s, "hll")
+		if b[4] == hllSparse {
+			if len(b) > hllSparseMaxBytes {
+				t.Fatalf("sparse HyperLogLog of %d bytes", len(b))
+			}
+			regs, _ = hllDecode(b)
+			continue
+		}
+		if len(b) != hllDenseSize {
+			t.Fatalf("dense HyperLogLog of %d bytes", len(b))
+		}
+		// The switch keeps every register.
+		reg, count := hllHash("elem:" + strconv.Itoa(i))
+		regs[reg] = max(regs[reg], count)
+		if dense, _ := hllDecode(b); !slices.Equal(dense, regs) {
+			t.Fatal("registers changed when turning dense")
+		}
+		return
+	}
+}
+
+func TestPFMergeEncodings(t *testing.T) {
+	s := NewStore()
+	addRange(t, s, "sparse1", "a", 100)
+	addRange(t, s, "sparse2", "b", 100)
+	addRange(t, s, "dense", "c", 5000)
+	if hllBytes(t, s, "sparse1")[4] != hllSparse || hllBytes(t, s, "dense")[4] != hllDense {
+		t.Fatal("unexpected encodings of the sources")
+	}
+	tests := []struct {
+		dst      string
+		srcs     []string
+		encoding byte
+	}{
+		{"m1", []string{"sparse1", "sparse2"}, hllSparse},
+		{"m2", []string{"sparse1", "dense"}, hllDense},
+		{"sparse2", []string{"dense", "missing"}, hllDense},
+	}
+	for _, tt := range tests {
+		want, err := s.PFCount(append([]string{tt.dst}, tt.srcs...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.PFMerge(tt.dst, tt.srcs); err != nil {
+			t.Fatal(err)
+		}
+		b := hllBytes(t, s, tt.dst)
+		if b[4] != tt.encoding {
+			t.Errorf("PFMERGE %s %v has encoding %d, want %d", tt.dst, tt.srcs, b[4], tt.encoding)
+		}
+		if got, _ := s.PFCount([]string{tt.dst}); got != want {
+			t.Errorf("PFMERGE %s %v counts %d, want %d", tt.dst, tt.srcs, got, want)
+		}
+	}
+	if card, _ := s.PFCount([]string{"m2"}); math.Abs(float64(card)-5100) > 5100*4*0.0081 {
+		t.Errorf("merged count %d, want about 5100", card)
+	}
+}
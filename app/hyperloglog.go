@@ -0,0 +1,29 @@
+package main
+
+import "strconv"
+
+func handlePfadd(c *Client, args []string) error {
+	changed, err := GlobalStore.PFAdd(args[1], args[2:])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if !changed {
+		return respWriter(c, INTEGER, "0")
+	}
+	return respWriter(c, INTEGER, "1")
+}
+
+func handlePfcount(c *Client, args []string) error {
+	card, err := GlobalStore.PFCount(args[1:])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.FormatUint(card, 10))
+}
+
+func handlePfmerge(c *Client, args []string) error {
+	if err := GlobalStore.PFMerge(args[1], args[2:]); err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, SIMPLE, "OK")
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"maps"
+	"math/rand"
+	"slices"
+	"strconv"
+)
+
+// setMaxIntsetEntries is the most members a set keeps in the intset
+// encoding.
+const setMaxIntsetEntries = 512
+
+// memberSet is the value of a set. Like in Redis, a set whose members are
+// all integers is kept as a sorted array of them (an intset) while it has at
+// most setMaxIntsetEntries members, which takes a fraction of the memory of a
+// hash table. It converts to a hash table for good once a member does not
+// fit. Then it also keeps the members in scan order for SSCAN.
+type memberSet struct {
+	ints    []int64
+	members map[string]struct{}
+	order   *scanOrder
+}
+
+func newMemberSet() *memberSet {
+	return &memberSet{}
+}
+
+// intsetMember returns the integer m stands for, if it is the canonical
+// decimal form of one, so that converting it back gives m again.
+func intsetMember(m string) (int64, bool) {
+	v, err := strconv.ParseInt(m, 10, 64)
+	return v, err == nil && strconv.FormatInt(v, 10) == m
+}
+
+// isIntset reports whether the set uses the intset encoding.
+func (s *memberSet) isIntset() bool {
+	return s.members == nil
+}
+
+func (s *memberSet) convert() {
+	s.members = make(map[string]struct{}, len(s.ints))
+	s.order = newScanOrder()
+	for _, v := range s.ints {
+		m := strconv.FormatInt(v, 10)
+		s.members[m] = struct{}{}
+		s.order.Add(m)
+	}
+	s.ints = nil
+}
+
+// Len returns the number of members. A nil set is empty.
+func (s *memberSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	if s.isIntset() {
+		return len(s.ints)
+	}
+	return len(s.members)
+}
+
+func (s *memberSet) Has(m string) bool {
+	if s == nil {
+		return false
+	}
+	if s.isIntset() {
+		v, ok := intsetMember(m)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(s.ints, v)
+		return found
+	}
+	_, ok := s.members[m]
+	return ok
+}
+
+// Add adds m and reports whether it was not a member yet.
+func (s *memberSet) Add(m string) bool {
+	if s.isIntset() {
+		if v, ok := intsetMember(m); ok {
+			i, found := slices.BinarySearch(s.ints, v)
+			if found {
+				return false
+			}
+			if len(s.ints) < setMaxIntsetEntries {
+				s.ints = slices.Insert(s.ints, i, v)
+				return true
+			}
+		}
+		s.convert()
+	}
+	if _, ok := s.members[m]; ok {
+		return false
+	}
+	s.members[m] = struct{}{}
+	s.order.Add(m)
+	return true
+}
+
+// Remove removes m and reports whether it was a member.
+func (s *memberSet) Remove(m string) bool {
+	if s.isIntset() {
+		v, ok := intsetMember(m)
+		if !ok {
+			return false
+		}
+		i, found := slices.BinarySearch(s.ints, v)
+		if found {
+			s.ints = slices.Delete(s.ints, i, i+1)
+		}
+		return found
+	}
+	if _, ok := s.members[m]; !ok {
+		return false
+	}
+	delete(s.members, m)
+	s.order.Remove(m)
+	return true
+}
+
+// Members returns the members, in ascending order for an intset and in no
+// particular order otherwise.
+func (s *memberSet) Members() []string {
+	if s == nil {
+		return []string{}
+	}
+	if s.isIntset() {
+		members := make([]string, len(s.ints))
+		for i, v := range s.ints {
+			members[i] = strconv.FormatInt(v, 10)
+		}
+		return members
+	}
+	return slices.AppendSeq(make([]string, 0, len(s.members)), maps.Keys(s.members))
+}
+
+// at returns the member at index i, in ascending order for an intset and in
+// scan order otherwise.
+func (s *memberSet) at(i int) string {
+	if s.isIntset() {
+		return strconv.FormatInt(s.ints[i], 10)
+	}
+	return s.order.At(i)
+}
+
+// Random returns a member picked at random from a set that is not empty.
+func (s *memberSet) Random() string {
+	return s.at(rand.Intn(s.Len()))
+}
+
+// Sample returns count distinct members picked at random, or all of them if
+// the set has no more than count.
+func (s *memberSet) Sample(count int) []string {
+	if count >= s.Len() {
+		return s.Members()
+	}
+	return randomSample(s.Len(), count, s.at, s.Members)
+}
+
+// Scan returns the members for a call of SSCAN, see scanOrder. An intset is
+// returned whole. A nil set is empty.
+func (s *memberSet) Scan(opts scanOptions) (uint64, []string) {
+	if s == nil || s.isIntset() {
+		return scanAll(s.Members(), opts)
+	}
+	return s.order.Scan(opts)
+}
+
+func (s *memberSet) Clone() *memberSet {
+	c := &memberSet{ints: slices.Clone(s.ints), members: maps.Clone(s.members)}
+	if !s.isIntset() {
+		c.order = newScanOrder()
+		for m := range s.members {
+			c.order.Add(m)
+		}
+	}
+	return c
+}
+
+// setInter returns the members common to all sets, stopping once it has
+// limit of them unless limit is 0. A nil set is empty.
+func setInter(sets []*memberSet, limit int) *memberSet {
+	result := newMemberSet()
+	smallest := slices.MinFunc(sets, func(a, b *memberSet) int { return a.Len() - b.Len() })
+	for _, m := range smallest.Members() {
+		if limit > 0 && result.Len() == limit {
+			break
+		}
+		if !slices.ContainsFunc(sets, func(s *memberSet) bool { return !s.Has(m) }) {
+			result.Add(m)
+		}
+	}
+	return result
+}
+
+func setUnion(sets []*memberSet) *memberSet {
+	result := newMemberSet()
+	for _, s := range sets {
+		for _, m := range s.Members() {
+			result.Add(m)
+		}
+	}
+	return result
+}
+
+// setDiff returns the members of the first set that are in none of the
+// others.
+func setDiff(sets []*memberSet) *memberSet {
+	result := newMemberSet()
+	for _, m := range sets[0].Members() {
+		if !slices.ContainsFunc(sets[1:], func(s *memberSet) bool { return s.Has(m) }) {
+			result.Add(m)
+		}
+	}
+	return result
+}
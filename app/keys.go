@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commands that work on keys of any type.
+
+func handleDel(c *Client, args []string) error {
+	deleted := 0
+	for _, key := range args[1:] {
+		if GlobalStore.Exists(key) && GlobalStore.Delete(key) {
+			deleted++
+		}
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(deleted))
+}
+
+func handleExists(c *Client, args []string) error {
+	count := 0
+	for _, key := range args[1:] {
+		if GlobalStore.Exists(key) {
+			count++
+		}
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(count))
+}
+
+func handleRename(c *Client, args []string) error {
+	src, dst := args[1], args[2]
+	nx := strings.ToUpper(args[0]) == "RENAMENX"
+	if !GlobalStore.Exists(src) {
+		return respWriter(c, ERROR, "ERR no such key")
+	}
+	if nx {
+		if GlobalStore.Exists(dst) {
+			return respWriter(c, INTEGER, "0")
+		}
+	}
+	GlobalStore.Rename(src, dst)
+	if nx {
+		return respWriter(c, INTEGER, "1")
+	}
+	return respWriter(c, SIMPLE, "OK")
+}
+
+func handleCopy(c *Client, args []string) error {
+	src, dst := args[1], args[2]
+	replace := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			// There is a single database, so only DB 0 can be addressed.
+			if i+1 == len(args) {
+				return respWriter(c, ERROR, "ERR syntax error")
+			}
+			i++
+			if db, err := strconv.Atoi(args[i]); err != nil || db != 0 {
+				return respWriter(c, ERROR, "ERR DB index is out of range")
+			}
+		default:
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+	}
+	if src == dst {
+		return respWriter(c, ERROR, "ERR source and destination objects are the same")
+	}
+	if !GlobalStore.Copy(src, dst, replace) {
+		return respWriter(c, INTEGER, "0")
+	}
+	return respWriter(c, INTEGER, "1")
+}
+
+// handleExpire implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT, which only
+// differ in the unit of the time argument and whether it is relative.
+func handleExpire(c *Client, args []string) error {
+	key := args[1]
+	name := strings.ToUpper(args[0])
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	var nx, xx, gt, lt bool
+	for _, opt := range args[3:] {
+		switch strings.ToUpper(opt) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return respWriter(c, ERROR, "ERR Unsupported option "+opt)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return respWriter(c, ERROR, "ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return respWriter(c, ERROR, "ERR GT and LT options at the same time are not compatible")
+	}
+
+	ms := n
+	if name == "EXPIRE" || name == "EXPIREAT" {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return respWriter(c, ERROR, fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(name)))
+		}
+		ms = n * 1000
+	}
+	if name == "EXPIRE" || name == "PEXPIRE" {
+		now := time.Now().UnixMilli()
+		if ms > math.MaxInt64-now {
+			return respWriter(c, ERROR, fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(name)))
+		}
+		ms += now
+	}
+	expiresAt := time.UnixMilli(ms)
+
+	if !GlobalStore.Exists(key) {
+		return respWriter(c, INTEGER, "0")
+	}
+	// A key without a TTL behaves as if its TTL were infinite for GT and LT.
+	current := GlobalStore.ExpiresAt(key)
+	volatile := !current.IsZero()
+	if (nx && volatile) || (xx && !volatile) ||
+		(gt && (!volatile || !expiresAt.After(current))) ||
+		(lt && volatile && !expiresAt.Before(current)) {
+		return respWriter(c, INTEGER, "0")
+	}
+	GlobalStore.SetExpire(key, expiresAt)
+	return respWriter(c, INTEGER, "1")
+}
+
+// handleTTL implements TTL, PTTL, EXPIRETIME and PEXPIRETIME. They reply -2
+// for a missing key and -1 for a key without a TTL.
+func handleTTL(c *Client, args []string) error {
+	key := args[1]
+	if !GlobalStore.Exists(key) {
+		return respWriter(c, INTEGER, "-2")
+	}
+	expiresAt := GlobalStore.ExpiresAt(key)
+	if expiresAt.IsZero() {
+		return respWriter(c, INTEGER, "-1")
+	}
+	var n int64
+	switch strings.ToUpper(args[0]) {
+	case "TTL":
+		n = (time.Until(expiresAt).Milliseconds() + 500) / 1000
+	case "PTTL":
+		n = time.Until(expiresAt).Milliseconds()
+	case "EXPIRETIME":
+		n = expiresAt.Unix()
+	case "PEXPIRETIME":
+		n = expiresAt.UnixMilli()
+	}
+	return respWriter(c, INTEGER, strconv.FormatInt(max(n, 0), 10))
+}
+
+func handlePersist(c *Client, args []string) error {
+	if GlobalStore.Persist(args[1]) {
+		return respWriter(c, INTEGER, "1")
+	}
+	return respWriter(c, INTEGER, "0")
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleBlpop implements BLPOP and BRPOP. It pops from the first of the keys
+// that holds a non-empty list, or waits until one does.
+func handleBlpop(c *Client, args []string) error {
+	keys := args[1 : len(args)-1]
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	pop := GlobalStore.LPop
+	if strings.EqualFold(args[0], "brpop") {
+		pop = GlobalStore.RPop
+	}
+	for _, key := range keys {
+		val, ok, err := pop(key)
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		if ok {
+			return respArray(c, []string{key, val})
+		}
+	}
+	if !c.canBlock() {
+		_, err := c.Write([]byte("*-1\r\n"))
+		return err
+	}
+	var key, val string
+	if !c.blockOn(keys, timeout, func(ready string) bool {
+		var ok bool
+		val, ok, _ = pop(ready)
+		key = ready
+		return ok
+	}) {
+		_, err := c.Write([]byte("*-1\r\n"))
+		return err
+	}
+	return respArray(c, []string{key, val})
+}
+
+// parseTimeout parses the timeout of a blocking list command, in seconds
+// with an optional fraction. 0 means forever.
+func parseTimeout(arg string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) || secs > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+func handleLRange(c *Client, args []string) error {
+	key := args[1]
+	left, err := strconv.Atoi(args[2])
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	right, err := strconv.Atoi(args[3])
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	n, err := GlobalStore.LLen(key)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	left, right = listRange(left, right, n)
+	if left > right {
+		return respArray(c, []string{})
+	}
+	elem, _ := GlobalStore.LRange(key, left, right)
+	return respArray(c, elem)
+}
+
+func handleRpush(c *Client, args []string) error {
+	key, value := args[1], args[2:]
+	length, err := GlobalStore.Rpush(key, value)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(length))
+}
+
+func handleLPush(c *Client, args []string) error {
+	key, value := args[1], args[2:]
+	length, err := GlobalStore.Lpush(key, value)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(length))
+}
+
+func handleLlen(c *Client, args []string) error {
+	length, err := GlobalStore.LLen(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(length))
+}
+
+// handleLpop implements LPOP and RPOP.
+func handleLpop(c *Client, args []string) error {
+	key := args[1]
+	left := strings.EqualFold(args[0], "lpop")
+	if len(args) > 2 {
+		return handleLpopMultiple(c, key, args[2], left)
+	}
+	pop := GlobalStore.LPop
+	if !left {
+		pop = GlobalStore.RPop
+	}
+	val, ok, err := pop(key)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if !ok {
+		if _, err := c.Write([]byte("$-1\r\n")); err != nil {
+			return err
+		}
+		return nil
+	} else {
+		return respWriter(c, BULK, val)
+	}
+}
+
+func handleLpopMultiple(conn io.Writer, key string, n string, left bool) error {
+	num, err := strconv.Atoi(n)
+	if err != nil || num < 0 {
+		return respWriter(conn, ERROR, "ERR value is out of range, must be positive")
+	}
+	pop := GlobalStore.LPopMultiple
+	if !left {
+		pop = GlobalStore.RPopMultiple
+	}
+	values, err := pop(key, num)
+	if err != nil {
+		return respWriter(conn, ERROR, err.Error())
+	}
+	if values == nil {
+		_, err := conn.Write([]byte("*-1\r\n"))
+		return err
+	}
+	return respArray(conn, values)
+}
+
+// handlePushx implements LPUSHX and RPUSHX, which only push to a list that
+// already exists.
+func handlePushx(c *Client, args []string) error {
+	key, value := args[1], args[2:]
+	n, err := GlobalStore.LLen(key)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if n == 0 {
+		return respWriter(c, INTEGER, "0")
+	}
+	push := GlobalStore.Lpush
+	if strings.EqualFold(args[0], "rpushx") {
+		push = GlobalStore.Rpush
+	}
+	length, _ := push(key, value)
+	return respWriter(c, INTEGER, strconv.Itoa(length))
+}
+
+func handleLindex(c *Client, args []string) error {
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	val, ok, err := GlobalStore.LIndex(args[1], index)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if !ok {
+		_, err := c.Write([]byte("$-1\r\n"))
+		return err
+	}
+	return respWriter(c, BULK, val)
+}
+
+func handleLset(c *Client, args []string) error {
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	if err := GlobalStore.LSet(args[1], index, args[3]); err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, SIMPLE, "OK")
+}
+
+// handleLinsert implements LINSERT key BEFORE | AFTER pivot element.
+func handleLinsert(c *Client, args []string) error {
+	var after bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	n, err := GlobalStore.LInsert(args[1], after, args[3], args[4])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+func handleLrem(c *Client, args []string) error {
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	n, err := GlobalStore.LRem(args[1], count, args[3])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+func handleLtrim(c *Client, args []string) error {
+	start, err := strconv.Atoi(args[2])
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.Atoi(args[3])
+	if err != nil {
+		return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+	}
+	if err := GlobalStore.LTrim(args[1], start, stop); err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, SIMPLE, "OK")
+}
+
+// handleLpos implements LPOS key element [RANK rank] [COUNT num-matches]
+// [MAXLEN len]. Without COUNT the reply is the first position only.
+func handleLpos(c *Client, args []string) error {
+	rank, count, maxlen := 1, -1, 0
+	for i := 3; i < len(args); i += 2 {
+		opt := strings.ToUpper(args[i])
+		if i+1 == len(args) || (opt != "RANK" && opt != "COUNT" && opt != "MAXLEN") {
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+		}
+		switch opt {
+		case "RANK":
+			if n == 0 {
+				return respWriter(c, ERROR, "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match")
+			}
+			if n == math.MinInt {
+				return respWriter(c, ERROR, "ERR value is out of range")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return respWriter(c, ERROR, "ERR COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return respWriter(c, ERROR, "ERR MAXLEN can't be negative")
+			}
+			maxlen = n
+		}
+	}
+	matches := count
+	if count < 0 {
+		matches = 1
+	}
+	positions, err := GlobalStore.LPos(args[1], args[2], rank, matches, maxlen)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if count < 0 {
+		if len(positions) == 0 {
+			_, err := c.Write([]byte("$-1\r\n"))
+			return err
+		}
+		return respWriter(c, INTEGER, strconv.Itoa(positions[0]))
+	}
+	if err := respArrayLen(c, len(positions)); err != nil {
+		return err
+	}
+	for _, pos := range positions {
+		if err := respWriter(c, INTEGER, strconv.Itoa(pos)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseDirection parses the LEFT | RIGHT argument of LMOVE and LMPOP.
+func parseDirection(arg string) (left bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// handleLmove implements LMOVE source destination LEFT | RIGHT LEFT | RIGHT
+// and BLMOVE, which takes a timeout and waits for source to get an element.
+func handleLmove(c *Client, args []string) error {
+	src, dst := args[1], args[2]
+	srcLeft, ok1 := parseDirection(args[3])
+	dstLeft, ok2 := parseDirection(args[4])
+	if !ok1 || !ok2 {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	blocking := strings.EqualFold(args[0], "blmove")
+	var timeout time.Duration
+	if blocking {
+		var err error
+		if timeout, err = parseTimeout(args[5]); err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+	}
+	val, ok, err := GlobalStore.LMove(src, dst, srcLeft, dstLeft)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if ok {
+		return respWriter(c, BULK, val)
+	}
+	if !blocking || !c.canBlock() {
+		_, err := c.Write([]byte("$-1\r\n"))
+		return err
+	}
+	if !c.blockOn([]string{src}, timeout, func(string) bool {
+		val, ok, _ = GlobalStore.LMove(src, dst, srcLeft, dstLeft)
+		return ok
+	}) {
+		_, err := c.Write([]byte("*-1\r\n"))
+		return err
+	}
+	return respWriter(c, BULK, val)
+}
+
+// handleLmpop implements LMPOP numkeys key [key ...] LEFT | RIGHT
+// [COUNT count] and BLMPOP, which takes a timeout before numkeys. It pops
+// from the first of the keys that holds a non-empty list.
+func handleLmpop(c *Client, args []string) error {
+	blocking := strings.EqualFold(args[0], "blmpop")
+	var timeout time.Duration
+	i := 1
+	if blocking {
+		var err error
+		if timeout, err = parseTimeout(args[1]); err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		i++
+	}
+	numkeys, err := strconv.Atoi(args[i])
+	if err != nil || numkeys <= 0 {
+		return respWriter(c, ERROR, "ERR numkeys should be greater than 0")
+	}
+	if numkeys > len(args)-i-2 {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	keys := args[i+1 : i+1+numkeys]
+	left, ok := parseDirection(args[i+1+numkeys])
+	if !ok {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	count := 1
+	if rest := args[i+2+numkeys:]; len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(rest[0], "COUNT") {
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+		if count, err = strconv.Atoi(rest[1]); err != nil || count <= 0 {
+			return respWriter(c, ERROR, "ERR count should be greater than 0")
+		}
+	}
+	pop := GlobalStore.LPopMultiple
+	if !left {
+		pop = GlobalStore.RPopMultiple
+	}
+	for _, key := range keys {
+		values, err := pop(key, count)
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		if len(values) > 0 {
+			return respKeyValues(c, key, values)
+		}
+	}
+	if !blocking || !c.canBlock() {
+		_, err := c.Write([]byte("*-1\r\n"))
+		return err
+	}
+	var key string
+	var values []string
+	if !c.blockOn(keys, timeout, func(ready string) bool {
+		values, _ = pop(ready, count)
+		key = ready
+		return len(values) > 0
+	}) {
+		_, err := c.Write([]byte("*-1\r\n"))
+		return err
+	}
+	return respKeyValues(c, key, values)
+}
+
+// respKeyValues writes the reply of LMPOP: the key and the popped elements.
+func respKeyValues(c *Client, key string, values []string) error {
+	if err := respArrayLen(c, 2); err != nil {
+		return err
+	}
+	if err := respWriter(c, BULK, key); err != nil {
+		return err
+	}
+	return respArray(c, values)
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestPopCountReplies(t *testing.T) {
+	tc := newTestClient(t)
+	if _, err := tc.Do("rpush", "test:pop", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tc.Do("del", "test:pop") })
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"lpop", "test:pop:missing", "2"}, "*-1\r\n"},
+		{[]string{"rpop", "test:pop:missing", "0"}, "*-1\r\n"},
+		{[]string{"lpop", "test:pop", "0"}, "*0\r\n"},
+		{[]string{"rpop", "test:pop", "0"}, "*0\r\n"},
+		{[]string{"lpop", "test:pop:missing"}, "$-1\r\n"},
+	}
+	for _, tt := range tests {
+		if err := tc.Send(tt.args...); err != nil {
+			t.Fatal(err)
+		}
+		line, err := tc.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != tt.want {
+			t.Errorf("%v replied %q, want %q", tt.args, line, tt.want)
+		}
+	}
+}
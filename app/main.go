@@ -1,32 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"strconv"
-	"strings"
-	"time"
-)
-
-const (
-	ECHO   = "ECHO"
-	PING   = "PING"
-	GET    = "GET"
-	SET    = "SET"
-	RPUSH  = "RPUSH"
-	LRANGE = "LRANGE"
-	LPUSH  = "LPUSH"
-	LLEN   = "LLEN"
-	LPOP   = "LPOP"
-	BLPOP  = "BLPOP"
-	TYPE   = "TYPE"
-	XADD   = "XADD"
-	XRANGE = "XRANGE"
-	XREAD  = "XREAD"
-	INCR   = "INCR"
-	MULTI  = "MULTI"
 )
 
 // Ensures gofmt doesn't remove the "net" and "os" imports in stage 1 (feel free to remove this!)
@@ -37,115 +16,85 @@ var GlobalStore = NewStore()
 
 func handleConnection(conn net.Conn) error {
 	defer conn.Close()
+	c := NewClient(conn)
+	defer c.Flush()
+	defer func() {
+		GlobalStore.mu.Lock()
+		c.unwatchAll()
+		GlobalStore.mu.Unlock()
+	}()
 
-	for {
-		args, err := respParser(conn)
-		if err != nil {
-			if err != io.EOF {
-				return err
-			} else {
-				fmt.Println("Connection closed")
-				return nil
-			}
+	defer close(c.done)
+	go c.readCommands()
+
+	for args := range c.commands {
+		if err := processCommand(c, args); err != nil {
+			return err
 		}
-		switch strings.ToUpper(args[0]) {
-		case PING:
-			if err = handlePing(conn); err != nil {
-				return err
-			}
-		case ECHO:
-			if err = handleEcho(conn, args[1]); err != nil {
-				return err
-			}
-		case GET:
-			if err = handleGet(conn, args[1]); err != nil {
-				return err
-			}
-		case SET:
-			if len(args) != 3 {
-				if strings.ToUpper(args[3]) != "PX" {
-					return fmt.Errorf("invalid arguments")
-				} else {
-					expires, err := strconv.Atoi(args[4])
-					if err != nil {
-						return err
-					}
-					if err = handleSet(conn, args[1], args[2], time.Duration(expires)*time.Millisecond); err != nil {
-						return err
-					}
-				}
-			} else {
-				if err = handleSet(conn, args[1], args[2], 24*time.Hour); err != nil {
-					return err
-				}
-			}
-		case RPUSH:
-			if err = handleRpush(conn, args[1], args[2:]); err != nil {
-				return err
-			}
-		case LRANGE:
-			if err = handleLRange(conn, args[1], args[2], args[3]); err != nil {
-				return err
-			}
-		case LPUSH:
-			if err = handleLPush(conn, args[1], args[2:]); err != nil {
-				return err
-			}
-		case LLEN:
-			if err = handleLlen(conn, args[1]); err != nil {
-				return err
-			}
-		case LPOP:
-			if len(args) != 2 {
-				if err = handleLpopMultiple(conn, args[1], args[2]); err != nil {
-					return err
-				}
-			} else {
-				if err = handleLpop(conn, args[1]); err != nil {
-					return err
-				}
-			}
-		case BLPOP:
-			if err = handleBlpop(conn, args[1], args[2]); err != nil {
-				return err
-			}
-		case TYPE:
-			if err = handleType(conn, args[1]); err != nil {
-				return err
-			}
-		case XADD:
-			if err = handleXadd(conn, args[1], args[2], args[3:]); err != nil {
+		if len(c.commands) == 0 || c.Buffered() >= replyBufferSize {
+			if err := c.Flush(); err != nil {
 				return err
 			}
-		case XRANGE:
-			if err = handleXrange(conn, args[1], args[2], args[3]); err != nil {
-				return err
-			}
-		case XREAD:
-			if strings.ToUpper(args[1]) != "BLOCK" {
-				if err = handleXread(conn, -1, args[2:]); err != nil {
-					return err
-				}
-			} else {
-				n, err := strconv.Atoi(args[2])
-				if err != nil {
-					return err
-				}
-				if err = handleXread(conn, n, args[4:]); err != nil {
-					return err
-				}
+		}
+	}
+	var protoErr respProtocolError
+	if errors.As(c.readErr, &protoErr) {
+		if err := respWriter(c, ERROR, protoErr.Error()); err != nil {
+			return err
+		}
+		return c.readErr
+	}
+	if c.readErr != io.EOF {
+		return c.readErr
+	}
+	fmt.Println("Connection closed")
+	return nil
+}
 
-			}
-		case INCR:
-			if err = handleIncr(conn, args[1]); err != nil {
-				return err
-			}
-		case MULTI:
-			if err = handleMulti(conn); err != nil {
-				return err
-			}
+// processCommand looks the command up and checks its arity. Inside MULTI the
+// command is then queued, otherwise it is executed while holding the store
+// lock, so that commands (and whole transactions) never interleave.
+func processCommand(c *Client, args []string) error {
+	cmd := lookupCommand(args[0])
+	if cmd == nil {
+		c.flagTransactionError()
+		return respWriter(c, ERROR, unknownCommandError(args))
+	}
+	if !cmd.CheckArity(len(args)) {
+		c.flagTransactionError()
+		return respWriter(c, ERROR, arityError(cmd.Name))
+	}
+	if c.inMulti && !isTransactionCommand(cmd) {
+		return queueCommand(c, args)
+	}
+	// Replies to pipelined commands are sent in one batch, but they have to
+	// reach the client before a command that may block.
+	if cmd.HasFlag(flagBlocking) {
+		if err := c.Flush(); err != nil {
+			return err
 		}
 	}
+	GlobalStore.mu.Lock()
+	defer GlobalStore.mu.Unlock()
+	err := cmd.Handler(c, args)
+	GlobalStore.ServeReadyKeys()
+	return err
+}
+
+// isTransactionCommand reports whether cmd controls the transaction itself and
+// so runs immediately even inside MULTI.
+func isTransactionCommand(cmd *Command) bool {
+	switch cmd.Name {
+	case "multi", "exec", "discard", "watch":
+		return true
+	}
+	return false
+}
+
+// executeCommand runs a command that was already validated, e.g. one queued
+// by MULTI. The caller holds the store lock.
+func executeCommand(c *Client, args []string) error {
+	return lookupCommand(args[0]).Handler(c, args)
 }
 
 func main() {
@@ -157,6 +106,7 @@ func main() {
 		fmt.Println("Failed to bind to port 6379")
 		os.Exit(1)
 	}
+	GlobalStore.StartActiveExpire()
 
 	for {
 		conn, err := l.Accept()
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClient talks RESP to a connection served by handleConnection over an
+// in-memory pipe.
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestClient(t testing.TB) *testClient {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleConnection(server)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return &testClient{conn: client, r: bufio.NewReader(client)}
+}
+
+// checked is a data structure that checks its own invariants and lists what
+// it holds, for tests that compare it with a simpler model.
+type checked[T any] interface {
+	checkInvariants() error
+	contents() []T
+}
+
+// checkContents fails t unless v keeps its invariants and holds want.
+func checkContents[T comparable](t *testing.T, v checked[T], want []T) {
+	t.Helper()
+	if err := v.checkInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.contents(); !slices.Equal(got, want) {
+		t.Fatalf("holds %d elements %v, want %d %v", len(got), got, len(want), want)
+	}
+}
+
+// replyError is an error reply.
+type replyError string
+
+// Send sends a command without reading its reply.
+func (tc *testClient) Send(args ...string) error {
+	cmd := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd = fmt.Appendf(cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := tc.conn.Write(cmd)
+	return err
+}
+
+// Do sends a command and returns its reply: a string, an int64, a
+// replyError, nil or a []any of those.
+func (tc *testClient) Do(args ...string) (any, error) {
+	if err := tc.Send(args...); err != nil {
+		return nil, err
+	}
+	return readReply(tc.r)
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return replyError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		elems := make([]any, n)
+		for i := range elems {
+			if elems[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// parallelKeys are the keys the samples in parallelCommands pick from, by
+// the kind of value they are meant for. {k} picks any of them, so commands
+// also meet values of the wrong type.
+var parallelKeys = map[string][]string{
+	"s": {"race:s0", "race:s1"},
+	"l": {"race:l0", "race:l1"},
+	"h": {"race:h0", "race:h1"},
+	"S": {"race:S0", "race:S1"},
+	"z": {"race:z0", "race:z1"},
+	"p": {"race:p0", "race:p1"},
+	"x": {"race:x0", "race:x1"},
+}
+
+// parallelCommands holds sample invocations of every command. Blocking
+// commands wait only briefly. Picking MULTI runs a whole transaction.
+var parallelCommands = map[string][]string{
+	"ping":         {"ping"},
+	"echo":         {"echo hi"},
+	"command":      {"command count", "command info get xadd"},
+	"info":         {"info", "info stats"},
+	"get":          {"get {s}"},
+	"set":          {"set {s} 1", "set {s} v px 20", "set {s} v nx get"},
+	"incr":         {"incr {s}"},
+	"type":         {"type {k}"},
+	"del":          {"del {k} {k}"},
+	"unlink":       {"unlink {k}"},
+	"exists":       {"exists {k} {k}"},
+	"rename":       {"rename {k} {k}"},
+	"renamenx":     {"renamenx {k} {k}"},
+	"copy":         {"copy {k} {k} replace"},
+	"expire":       {"expire {k} 1"},
+	"pexpire":      {"pexpire {k} 15"},
+	"expireat":     {"expireat {k} 1"},
+	"pexpireat":    {"pexpireat {k} 99999999999999"},
+	"ttl":          {"ttl {k}"},
+	"pttl":         {"pttl {k}"},
+	"expiretime":   {"expiretime {k}"},
+	"pexpiretime":  {"pexpiretime {k}"},
+	"persist":      {"persist {k}"},
+	"rpush":        {"rpush {l} a b c"},
+	"lpush":        {"lpush {l} a b c"},
+	"lrange":       {"lrange {l} 0 -1"},
+	"llen":         {"llen {l}"},
+	"lpop":         {"lpop {l}", "lpop {l} 2"},
+	"rpop":         {"rpop {l} 2"},
+	"lpushx":       {"lpushx {l} x"},
+	"rpushx":       {"rpushx {l} y"},
+	"lindex":       {"lindex {l} 1"},
+	"lset":         {"lset {l} 0 z"},
+	"linsert":      {"linsert {l} before b q"},
+	"lrem":         {"lrem {l} 1 a"},
+	"ltrim":        {"ltrim {l} 0 100"},
+	"lpos":         {"lpos {l} c rank -1"},
+	"lmove":        {"lmove {l} {l} left right"},
+	"blmove":       {"blmove {l} {l} right left 0.01"},
+	"lmpop":        {"lmpop 2 {l} {l} left count 2"},
+	"blmpop":       {"blmpop 0.01 2 {l} {l} right"},
+	"blpop":        {"blpop {l} {l} 0.01"},
+	"brpop":        {"brpop {l} 0.01"},
+	"hset":         {"hset {h} a 1 b 2"},
+	"hmset":        {"hmset {h} c 3"},
+	"hsetnx":       {"hsetnx {h} d 4"},
+	"hget":         {"hget {h} a"},
+	"hmget":        {"hmget {h} a z"},
+	"hgetall":      {"hgetall {h}"},
+	"hkeys":        {"hkeys {h}"},
+	"hvals":        {"hvals {h}"},
+	"hdel":         {"hdel {h} a c"},
+	"hexists":      {"hexists {h} b"},
+	"hlen":         {"hlen {h}"},
+	"hstrlen":      {"hstrlen {h} b"},
+	"hincrby":      {"hincrby {h} n 2"},
+	"hincrbyfloat": {"hincrbyfloat {h} f 0.5"},
+	"hrandfield":   {"hrandfield {h} -3 withvalues"},
+	"hscan":        {"hscan {h} 0 count 2"},
+	"sadd":         {"sadd {S} a b 1 2"},
+	"srem":         {"srem {S} a 1"},
+	"sismember":    {"sismember {S} b"},
+	"smismember":   {"smismember {S} a b"},
+	"smembers":     {"smembers {S}"},
+	"scard":        {"scard {S}"},
+	"spop":         {"spop {S}", "spop {S} 2"},
+	"srandmember":  {"srandmember {S} -2"},
+	"smove":        {"smove {S} {S} b"},
+	"sinter":       {"sinter {S} {S}"},
+	"sunion":       {"sunion {S} {S}"},
+	"sdiff":        {"sdiff {S} {S}"},
+	"sinterstore":  {"sinterstore {S} {S} {S}"},
+	"sunionstore":  {"sunionstore {S} {S} {S}"},
+	"sdiffstore":   {"sdiffstore {S} {S} {S}"},
+	"sintercard":   {"sintercard 2 {S} {S} limit 1"},
+	"sscan":        {"sscan {S} 0 match * count 3"},
+	"zadd":         {"zadd {z} 1 a 2 b 3 c"},
+	"zincrby":      {"zincrby {z} 1 a"},
+	"zrem":         {"zrem {z} a"},
+	"zscore":       {"zscore {z} b"},
+	"zmscore":      {"zmscore {z} a b"},
+	"zcard":        {"zcard {z}"},
+	"zcount":       {"zcount {z} -inf +inf"},
+	"zrank":        {"zrank {z} b"},
+	"zrevrank":     {"zrevrank {z} c"},
+	"zrange":       {"zrange {z} 0 -1 withscores", "zrange {z} (1 +inf byscore limit 0 2"},
+	"pfadd":        {"pfadd {p} a b c"},
+	"pfcount":      {"pfcount {p} {p}"},
+	"pfmerge":      {"pfmerge {p} {p}"},
+	"setbit":       {"setbit {s} 7 1"},
+	"getbit":       {"getbit {s} 7"},
+	"bitcount":     {"bitcount {s}"},
+	"bitpos":       {"bitpos {s} 1"},
+	"bitop":        {"bitop or {s} {s} {s}"},
+	"bitfield":     {"bitfield {s} incrby u8 0 1 get u4 0"},
+	"bitfield_ro":  {"bitfield_ro {s} get u8 0"},
+	"xadd":         {"xadd {x} * f v", "xadd {x} maxlen ~ 50 * f v"},
+	"xrange":       {"xrange {x} - + count 5"},
+	"xrevrange":    {"xrevrange {x} + - count 5"},
+	"xread":        {"xread count 2 streams {x} 0", "xread block 10 streams {x} $"},
+	"xlen":         {"xlen {x}"},
+	"xdel":         {"xdel {x} 0-1"},
+	"xtrim":        {"xtrim {x} maxlen 20"},
+	"xinfo":        {"xinfo stream {x}"},
+	"xgroup":       {"xgroup create {x} g $ mkstream", "xgroup setid {x} g 0", "xgroup createconsumer {x} g c", "xgroup delconsumer {x} g c", "xgroup destroy {x} g"},
+	"xreadgroup":   {"xreadgroup group g c count 2 streams {x} >", "xreadgroup group g c block 10 streams {x} >"},
+	"xack":         {"xack {x} g 0-1"},
+	"xclaim":       {"xclaim {x} g d 0 0-1"},
+	"xautoclaim":   {"xautoclaim {x} g d 0 0 count 5"},
+	"xpending":     {"xpending {x} g", "xpending {x} g - + 10"},
+	"multi":        {"multi"},
+	"exec":         {"exec"},
+	"discard":      {"discard"},
+	"watch":        {"watch {k} {k}"},
+	"unwatch":      {"unwatch"},
+}
+
+var parallelNames = func() []string {
+	names := make([]string, 0, len(parallelCommands))
+	for name := range parallelCommands {
+		names = append(names, name)
+	}
+	return names
+}()
+
+// sampleCommand returns the arguments of a random sample of command name.
+func sampleCommand(rnd *rand.Rand, name string) []string {
+	samples := parallelCommands[name]
+	args := strings.Fields(samples[rnd.Intn(len(samples))])
+	for i, arg := range args {
+		if len(arg) < 3 || arg[0] != '{' || arg[len(arg)-1] != '}' {
+			continue
+		}
+		kind := arg[1 : len(arg)-1]
+		if kind == "k" {
+			kind = string("slhSzpx"[rnd.Intn(7)])
+		}
+		keys := parallelKeys[kind]
+		args[i] = keys[rnd.Intn(len(keys))]
+	}
+	return args
+}
+
+// checkSampleReply fails on replies that mean a sample itself is wrong.
+func checkSampleReply(args []string, reply any) error {
+	if err, ok := reply.(replyError); ok {
+		for _, bad := range []string{"ERR unknown", "ERR wrong number of arguments", "ERR syntax error"} {
+			if strings.HasPrefix(string(err), bad) {
+				return fmt.Errorf("%q: %s", args, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runRandomCommand runs a random sample, or a transaction of a few of them
+// if it picks MULTI.
+func runRandomCommand(tc *testClient, rnd *rand.Rand) error {
+	name := parallelNames[rnd.Intn(len(parallelNames))]
+	if name != "multi" {
+		args := sampleCommand(rnd, name)
+		reply, err := tc.Do(args...)
+		if err != nil {
+			return err
+		}
+		return checkSampleReply(args, reply)
+	}
+	if rnd.Intn(2) == 0 {
+		if _, err := tc.Do(sampleCommand(rnd, "watch")...); err != nil {
+			return err
+		}
+	}
+	if _, err := tc.Do("multi"); err != nil {
+		return err
+	}
+	for n := rnd.Intn(4); n > 0; n-- {
+		name := parallelNames[rnd.Intn(len(parallelNames))]
+		if isTransactionCommand(commandTable[name]) {
+			continue
+		}
+		args := sampleCommand(rnd, name)
+		reply, err := tc.Do(args...)
+		if err != nil {
+			return err
+		}
+		if reply != "QUEUED" {
+			return fmt.Errorf("%q in MULTI: %v", args, reply)
+		}
+	}
+	end := "exec"
+	if rnd.Intn(5) == 0 {
+		end = "discard"
+	}
+	reply, err := tc.Do(end)
+	if err != nil {
+		return err
+	}
+	if replies, ok := reply.([]any); ok {
+		for _, r := range replies {
+			if err := checkSampleReply([]string{"exec"}, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TestParallelCommands runs every command from many connections at once, with
+// the active expire cycle going, so that go test -race catches state touched
+// without the store lock.
+func TestParallelCommands(t *testing.T) {
+	for name := range commandTable {
+		if _, ok := parallelCommands[name]; !ok {
+			t.Errorf("no sample of %s in parallelCommands", name)
+		}
+	}
+	t.Cleanup(GlobalStore.StartActiveExpire())
+	duration := 2 * time.Second
+	if testing.Short() {
+		duration = 200 * time.Millisecond
+	}
+	deadline := time.Now().Add(duration)
+	var wg sync.WaitGroup
+	for i := range 16 {
+		tc := newTestClient(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(i)))
+			for time.Now().Before(deadline) {
+				if err := runRandomCommand(tc, rnd); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if reply, err := newTestClient(t).Do("ping"); err != nil || reply != "PONG" {
+		t.Fatalf("PING after the run: %v, %v", reply, err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import "slices"
+
+// quicklistNodeSize is the most elements a quicklist node holds.
+const quicklistNodeSize = 128
+
+// quicklist is the encoding of lists: a doubly linked list of nodes holding
+// up to quicklistNodeSize elements each, like the quicklist of Redis. Pushing
+// and popping at either end is O(1), finding an index walks nodes rather than
+// elements, and the memory of popped elements is released as nodes empty.
+type quicklist struct {
+	head, tail *quicklistNode
+	count      int
+}
+
+type quicklistNode struct {
+	prev, next *quicklistNode
+	entries    []string
+}
+
+func newQuicklist() *quicklist {
+	return &quicklist{}
+}
+
+// Len returns the number of elements. A nil quicklist is empty.
+func (q *quicklist) Len() int {
+	if q == nil {
+		return 0
+	}
+	return q.count
+}
+
+func (q *quicklist) PushHead(v string) {
+	if q.head == nil || len(q.head.entries) >= quicklistNodeSize {
+		q.insertNode(nil, &quicklistNode{})
+	}
+	q.head.entries = slices.Insert(q.head.entries, 0, v)
+	q.count++
+}
+
+func (q *quicklist) PushTail(v string) {
+	if q.tail == nil || len(q.tail.entries) >= quicklistNodeSize {
+		q.insertNode(q.tail, &quicklistNode{})
+	}
+	q.tail.entries = append(q.tail.entries, v)
+	q.count++
+}
+
+func (q *quicklist) PopHead() (string, bool) {
+	if q.count == 0 {
+		return "", false
+	}
+	n := q.head
+	v := n.entries[0]
+	n.entries[0] = ""
+	n.entries = n.entries[1:]
+	q.count--
+	if len(n.entries) == 0 {
+		q.unlinkNode(n)
+	}
+	return v, true
+}
+
+func (q *quicklist) PopTail() (string, bool) {
+	if q.count == 0 {
+		return "", false
+	}
+	n := q.tail
+	last := len(n.entries) - 1
+	v := n.entries[last]
+	n.entries[last] = ""
+	n.entries = n.entries[:last]
+	q.count--
+	if len(n.entries) == 0 {
+		q.unlinkNode(n)
+	}
+	return v, true
+}
+
+// DropHead removes the first n elements.
+func (q *quicklist) DropHead(n int) {
+	for n > 0 && q.head != nil {
+		node := q.head
+		if len(node.entries) <= n {
+			n -= len(node.entries)
+			q.count -= len(node.entries)
+			q.unlinkNode(node)
+			continue
+		}
+		clear(node.entries[:n])
+		node.entries = node.entries[n:]
+		q.count -= n
+		return
+	}
+}
+
+// DropTail removes the last n elements.
+func (q *quicklist) DropTail(n int) {
+	for n > 0 && q.tail != nil {
+		node := q.tail
+		if len(node.entries) <= n {
+			n -= len(node.entries)
+			q.count -= len(node.entries)
+			q.unlinkNode(node)
+			continue
+		}
+		keep := len(node.entries) - n
+		clear(node.entries[keep:])
+		node.entries = node.entries[:keep]
+		q.count -= n
+		return
+	}
+}
+
+// locate returns the node holding the element at index i, which must be in
+// range, and the offset of the element in it. It walks from the nearer end.
+func (q *quicklist) locate(i int) (*quicklistNode, int) {
+	if i < q.count/2 {
+		n := q.head
+		for i >= len(n.entries) {
+			i -= len(n.entries)
+			n = n.next
+		}
+		return n, i
+	}
+	i = q.count - 1 - i
+	n := q.tail
+	for i >= len(n.entries) {
+		i -= len(n.entries)
+		n = n.prev
+	}
+	return n, len(n.entries) - 1 - i
+}
+
+// Index returns the element at index i, which must be in range.
+func (q *quicklist) Index(i int) string {
+	n, off := q.locate(i)
+	return n.entries[off]
+}
+
+// Set replaces the element at index i, which must be in range.
+func (q *quicklist) Set(i int, v string) {
+	n, off := q.locate(i)
+	n.entries[off] = v
+}
+
+// Insert inserts v so that it ends up at index i, 0 <= i <= Len(). A full
+// node is split in two.
+func (q *quicklist) Insert(i int, v string) {
+	if i == 0 {
+		q.PushHead(v)
+		return
+	}
+	if i == q.count {
+		q.PushTail(v)
+		return
+	}
+	n, off := q.locate(i)
+	if len(n.entries) >= quicklistNodeSize {
+		half := len(n.entries) / 2
+		split := &quicklistNode{entries: slices.Clone(n.entries[half:])}
+		clear(n.entries[half:])
+		n.entries = n.entries[:half]
+		q.insertNode(n, split)
+		if off >= half {
+			n, off = split, off-half
+		}
+	}
+	n.entries = slices.Insert(n.entries, off, v)
+	q.count++
+}
+
+// Range returns the elements from start to stop inclusive, which must be a
+// non-empty range within the list.
+func (q *quicklist) Range(start, stop int) []string {
+	elems := make([]string, 0, stop-start+1)
+	n, off := q.locate(start)
+	for len(elems) < cap(elems) {
+		end := min(len(n.entries), off+cap(elems)-len(elems))
+		elems = append(elems, n.entries[off:end]...)
+		n, off = n.next, 0
+	}
+	return elems
+}
+
+// Each calls f with every element and its index, from the tail if reverse
+// is set, until f returns false.
+func (q *quicklist) Each(reverse bool, f func(i int, v string) bool) {
+	if q == nil {
+		return
+	}
+	if !reverse {
+		i := 0
+		for n := q.head; n != nil; n = n.next {
+			for _, v := range n.entries {
+				if !f(i, v) {
+					return
+				}
+				i++
+			}
+		}
+		return
+	}
+	i := q.count - 1
+	for n := q.tail; n != nil; n = n.prev {
+		for j := len(n.entries) - 1; j >= 0; j-- {
+			if !f(i, n.entries[j]) {
+				return
+			}
+			i--
+		}
+	}
+}
+
+func (q *quicklist) Clone() *quicklist {
+	c := newQuicklist()
+	q.Each(false, func(_ int, v string) bool {
+		c.PushTail(v)
+		return true
+	})
+	return c
+}
+
+// insertNode links n after prev, or at the head if prev is nil.
+func (q *quicklist) insertNode(prev, n *quicklistNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = q.head
+		q.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		q.tail = n
+	} else {
+		n.next.prev = n
+	}
+}
+
+func (q *quicklist) unlinkNode(n *quicklistNode) {
+	if n.prev == nil {
+		q.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		q.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
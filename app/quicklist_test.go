@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// checkInvariants checks that the nodes are linked both ways, none of them
+// empty or over quicklistNodeSize, and that they hold count elements.
+func (q *quicklist) checkInvariants() error {
+	var prev *quicklistNode
+	count := 0
+	for n := q.head; n != nil; prev, n = n, n.next {
+		if n.prev != prev {
+			return errors.New("broken prev link")
+		}
+		if len(n.entries) == 0 || len(n.entries) > quicklistNodeSize {
+			return fmt.Errorf("node of %d entries", len(n.entries))
+		}
+		count += len(n.entries)
+	}
+	if q.tail != prev {
+		return errors.New("tail is not the last node")
+	}
+	if count != q.count {
+		return fmt.Errorf("nodes hold %d elements, count is %d", count, q.count)
+	}
+	return nil
+}
+
+func (q *quicklist) contents() []string {
+	var elems []string
+	for n := q.head; n != nil; n = n.next {
+		elems = append(elems, n.entries...)
+	}
+	return elems
+}
+
+func quicklistOf(n int) (*quicklist, []string) {
+	q := newQuicklist()
+	var elems []string
+	for i := range n {
+		v := strconv.Itoa(i)
+		q.PushTail(v)
+		elems = append(elems, v)
+	}
+	return q, elems
+}
+
+func TestQuicklistInsertSplit(t *testing.T) {
+	q, want := quicklistOf(quicklistNodeSize)
+	if q.head != q.tail {
+		t.Fatal("a full node was split before it overflowed")
+	}
+	// Into the first half of the full node, then into the second half of
+	// what is left of it.
+	q.Insert(10, "a")
+	want = slices.Insert(want, 10, "a")
+	checkContents(t, q, want)
+	if q.head == q.tail || len(q.head.entries) != quicklistNodeSize/2+1 {
+		t.Fatalf("full node split into %d and %d entries", len(q.head.entries), len(q.tail.entries))
+	}
+	for len(q.head.entries) < quicklistNodeSize {
+		q.Insert(1, "b")
+		want = slices.Insert(want, 1, "b")
+	}
+	q.Insert(quicklistNodeSize-1, "c")
+	want = slices.Insert(want, quicklistNodeSize-1, "c")
+	checkContents(t, q, want)
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := range 5000 {
+		at := rnd.Intn(len(want) + 1)
+		v := "r" + strconv.Itoa(i)
+		q.Insert(at, v)
+		want = slices.Insert(want, at, v)
+	}
+	checkContents(t, q, want)
+}
+
+func TestQuicklistDrop(t *testing.T) {
+	q, want := quicklistOf(1000)
+	q.PopHead()
+	want = want[1:]
+	for _, n := range []int{1, quicklistNodeSize - 1, 2*quicklistNodeSize + 5, 0} {
+		q.DropHead(n)
+		want = want[n:]
+		checkContents(t, q, want)
+		q.DropTail(n)
+		want = want[:len(want)-n]
+		checkContents(t, q, want)
+	}
+	// The head node is now partial; drop exactly through its end.
+	q.DropHead(len(q.head.entries))
+	want = want[len(want)-q.Len():]
+	checkContents(t, q, want)
+	q.DropTail(q.Len() + 10)
+	checkContents(t, q, nil)
+	if q.head != nil || q.tail != nil {
+		t.Fatal("empty list kept nodes")
+	}
+	q.DropHead(1)
+	checkContents(t, q, nil)
+}
+
+func TestQuicklistLocate(t *testing.T) {
+	// Uneven nodes, so that walking from either end meets partial nodes.
+	q, want := quicklistOf(10 * quicklistNodeSize)
+	rnd := rand.New(rand.NewSource(2))
+	for i := range 300 {
+		at := rnd.Intn(len(want) + 1)
+		v := "x" + strconv.Itoa(i)
+		q.Insert(at, v)
+		want = slices.Insert(want, at, v)
+	}
+	q.DropHead(7)
+	q.DropTail(9)
+	want = want[7 : len(want)-9]
+	checkContents(t, q, want)
+	for i, v := range want {
+		n, off := q.locate(i)
+		if n.entries[off] != v || q.Index(i) != v {
+			t.Fatalf("locate(%d) = %q, want %q", i, n.entries[off], v)
+		}
+	}
+	first, _ := q.locate(0)
+	last, off := q.locate(q.Len() - 1)
+	if first != q.head || last != q.tail || off != len(q.tail.entries)-1 {
+		t.Fatal("locate of the ends does not return the end nodes")
+	}
+	q.Set(q.Len()/2, "mid")
+	want[len(want)/2] = "mid"
+	if got := q.Range(0, q.Len()-1); !slices.Equal(got, want) {
+		t.Fatal("Range of the whole list differs")
+	}
+	if got := q.Range(100, 900); !slices.Equal(got, want[100:901]) {
+		t.Fatal("Range across nodes differs")
+	}
+}
+
+// benchList is what the list benchmarks need of an encoding, so that the
+// quicklist can be compared with the plain slice lists were kept in before.
+type benchList interface {
+	PushHead(v string)
+	PushTail(v string)
+	PopHead() (string, bool)
+	Index(i int) string
+	Range(start, stop int) []string
+	Len() int
+}
+
+// sliceList is a list kept in a single slice.
+type sliceList struct {
+	elems []string
+}
+
+func (l *sliceList) PushHead(v string) { l.elems = append([]string{v}, l.elems...) }
+
+func (l *sliceList) PushTail(v string) { l.elems = append(l.elems, v) }
+
+func (l *sliceList) PopHead() (string, bool) {
+	if len(l.elems) == 0 {
+		return "", false
+	}
+	v := l.elems[0]
+	l.elems = l.elems[1:]
+	return v, true
+}
+
+func (l *sliceList) Index(i int) string { return l.elems[i] }
+
+func (l *sliceList) Range(start, stop int) []string { return slices.Clone(l.elems[start : stop+1]) }
+
+func (l *sliceList) Len() int { return len(l.elems) }
+
+const benchListLen = 1 << 20
+
+// benchLists runs f on a list of benchListLen elements in each encoding.
+func benchLists(b *testing.B, f func(b *testing.B, l benchList)) {
+	encodings := []struct {
+		name string
+		new  func() benchList
+	}{
+		{"quicklist", func() benchList { return newQuicklist() }},
+		{"slice", func() benchList { return &sliceList{} }},
+	}
+	for _, enc := range encodings {
+		b.Run(enc.name, func(b *testing.B) {
+			l := enc.new()
+			for i := range benchListLen {
+				l.PushTail(strconv.Itoa(i))
+			}
+			b.ResetTimer()
+			f(b, l)
+		})
+	}
+}
+
+// BenchmarkListPushHead measures LPUSH, which copied the whole slice.
+func BenchmarkListPushHead(b *testing.B) {
+	benchLists(b, func(b *testing.B, l benchList) {
+		for range b.N {
+			l.PushHead("v")
+		}
+	})
+}
+
+func BenchmarkListPushTail(b *testing.B) {
+	benchLists(b, func(b *testing.B, l benchList) {
+		for range b.N {
+			l.PushTail("v")
+		}
+	})
+}
+
+// BenchmarkListPopHead measures LPOP. It pushes back what it pops so the
+// list keeps its length, which made the slice reallocate as it crept along.
+func BenchmarkListPopHead(b *testing.B) {
+	benchLists(b, func(b *testing.B, l benchList) {
+		for range b.N {
+			v, _ := l.PopHead()
+			l.PushTail(v)
+		}
+	})
+}
+
+func BenchmarkListIndex(b *testing.B) {
+	benchLists(b, func(b *testing.B, l benchList) {
+		rnd := rand.New(rand.NewSource(1))
+		for range b.N {
+			l.Index(rnd.Intn(l.Len()))
+		}
+	})
+}
+
+// BenchmarkListRange measures LRANGE of 100 elements at a random offset.
+func BenchmarkListRange(b *testing.B) {
+	benchLists(b, func(b *testing.B, l benchList) {
+		rnd := rand.New(rand.NewSource(1))
+		for range b.N {
+			start := rnd.Intn(l.Len() - 100)
+			l.Range(start, start+99)
+		}
+	})
+}
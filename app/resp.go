@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
-	"net"
+	"io"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 type respStringType string
@@ -18,22 +21,142 @@ const (
 	ERROR   respStringType = "ERROR"
 )
 
-func respParser(conn net.Conn) ([]string, error) {
-	var buf = make([]byte, 1024)
-	n, err := conn.Read(buf)
+const (
+	maxInlineSize    = 64 * 1024
+	maxMultiBulkLen  = 1024 * 1024
+	maxBulkLen       = 512 * 1024 * 1024
+	respReadBufferSz = 16 * 1024
+)
+
+// respProtocolError is returned by the reader when the client sent something
+// that is not valid RESP. The connection cannot be resynchronised after it.
+type respProtocolError string
+
+func (e respProtocolError) Error() string {
+	return "ERR Protocol error: " + string(e)
+}
+
+// respReader decodes RESP2 commands from a connection. It keeps its own
+// buffer so a command may be split across several reads and several commands
+// may arrive in a single read (pipelining).
+type respReader struct {
+	rd *bufio.Reader
+}
+
+func newRespReader(r io.Reader) *respReader {
+	return &respReader{rd: bufio.NewReaderSize(r, respReadBufferSz)}
+}
+
+// ReadCommand returns the next command sent by the client. Both multibulk
+// (*N followed by N bulk strings) and inline commands are accepted. Empty
+// commands are skipped.
+func (r *respReader) ReadCommand() ([]string, error) {
+	for {
+		prefix, err := r.rd.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		var args []string
+		if prefix[0] == '*' {
+			args, err = r.readMultiBulk()
+		} else {
+			args, err = r.readInline()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+func (r *respReader) readLine(limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.rd.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", respProtocolError("too big request line")
+		}
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			if len(line) > 0 {
+				return "", unexpectedEOF(err)
+			}
+			return "", err
+		}
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	return string(line), nil
+}
+
+func (r *respReader) readInline() ([]string, error) {
+	line, err := r.readLine(maxInlineSize)
 	if err != nil {
 		return nil, err
 	}
-	sep := []byte{'\r', '\n'}
-	parts := bytes.Split(buf[:n], sep)
-	var args []string
-	for i := 2; i < len(parts); i += 2 {
-		args = append(args, string(parts[i]))
+	return strings.Fields(line), nil
+}
+
+func (r *respReader) readMultiBulk() ([]string, error) {
+	line, err := r.readLine(maxInlineSize)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxMultiBulkLen {
+		return nil, respProtocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
 	}
 	return args, nil
 }
 
-func respAny(conn net.Conn, data interface{}) error {
+func (r *respReader) readBulk() (string, error) {
+	line, err := r.readLine(maxInlineSize)
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", respProtocolError(fmt.Sprintf("expected '$', got '%s'", line[:min(len(line), 1)]))
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxBulkLen {
+		return "", respProtocolError("invalid bulk length")
+	}
+	// The payload is read by length, so it may itself contain CRLF.
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.rd, buf); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", respProtocolError("bulk string not terminated by CRLF")
+	}
+	return string(buf[:n]), nil
+}
+
+// unexpectedEOF turns a clean EOF in the middle of a command into
+// io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func respAny(conn io.Writer, data interface{}) error {
 	switch t := data.(type) {
 	case XRangeSerialized:
 		entryMsg := "*2\r\n"
@@ -43,14 +166,16 @@ func respAny(conn net.Conn, data interface{}) error {
 		if err := respWriter(conn, BULK, t.id); err != nil {
 			return err
 		}
+		if t.deleted {
+			_, err := conn.Write([]byte("*-1\r\n"))
+			return err
+		}
 		if err := respArray(conn, t.fields); err != nil {
 			return err
 		}
 		return nil
 	case []XRangeSerialized:
 		msg := fmt.Sprintf("*%d\r\n", len(t))
-		fmt.Println(len(t))
-		fmt.Println("Wtf")
 		if _, err := conn.Write([]byte(msg)); err != nil {
 			return err
 		}
@@ -84,19 +209,24 @@ func respAny(conn net.Conn, data interface{}) error {
 	}
 }
 
-func respArray(conn net.Conn, a []string) error {
-	msg := fmt.Sprintf("*%d\r\n", len(a))
-	for _, v := range a {
-		msg += fmt.Sprintf("$%d\r\n", len(v))
-		msg += fmt.Sprintf("%s\r\n", v)
-	}
+func respArrayLen(conn io.Writer, n int) error {
+	msg := fmt.Sprintf("*%d\r\n", n)
 	if _, err := conn.Write([]byte(msg)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func respWriter(conn net.Conn, strType respStringType, str string) error {
+func respArray(conn io.Writer, a []string) error {
+	msg := fmt.Appendf(nil, "*%d\r\n", len(a))
+	for _, v := range a {
+		msg = fmt.Appendf(msg, "$%d\r\n%s\r\n", len(v), v)
+	}
+	_, err := conn.Write(msg)
+	return err
+}
+
+func respWriter(conn io.Writer, strType respStringType, str string) error {
 	var msg string
 	switch strType {
 	case BULK:
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// HSCAN and SSCAN walk the members of a value in the order of a hash of each
+// member; the cursor is the hash to continue from. A member that is there for
+// the whole scan is returned at least once however the value changes in
+// between, as Redis guarantees, because adding or removing other members does
+// not move it in that order. Hashes and sets that are not intsets keep their
+// members in that order in a skip list updated on every write, so a call
+// seeks to its cursor in O(log n) instead of ordering the whole value again.
+
+type scanOptions struct {
+	cursor   uint64
+	match    string
+	count    int
+	novalues bool
+}
+
+// parseScan parses cursor [MATCH pattern] [COUNT count], plus NOVALUES if
+// allowed, from args.
+func parseScan(args []string, allowNoValues bool) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return opts, errors.New("ERR invalid cursor")
+	}
+	opts.cursor = cursor
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "NOVALUES" && allowNoValues:
+			opts.novalues = true
+		case opt == "MATCH" && i+1 < len(args):
+			opts.match = args[i+1]
+			i++
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errors.New("ERR value is not an integer or out of range")
+			}
+			if n < 1 {
+				return opts, errors.New("ERR syntax error")
+			}
+			opts.count = n
+			i++
+		default:
+			return opts, errors.New("ERR syntax error")
+		}
+	}
+	return opts, nil
+}
+
+// scanHash returns the position of member in scan order. It has 53 bits, so
+// that it is exact as the score of a skip list node.
+func scanHash(member string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	return h.Sum64() >> 11
+}
+
+// scanOrder keeps members ordered by scanHash, then by member.
+type scanOrder struct {
+	zsl *zskiplist
+}
+
+func newScanOrder() *scanOrder {
+	return &scanOrder{zsl: newZskiplist()}
+}
+
+// Add adds member, which must not be in the order yet.
+func (o *scanOrder) Add(member string) {
+	o.zsl.Insert(float64(scanHash(member)), member)
+}
+
+func (o *scanOrder) Remove(member string) {
+	o.zsl.Delete(float64(scanHash(member)), member)
+}
+
+// Members returns all members in scan order.
+func (o *scanOrder) Members() []string {
+	members := make([]string, 0, o.zsl.length)
+	for x := o.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		members = append(members, x.member)
+	}
+	return members
+}
+
+// At returns the member at index i in scan order, in O(log n).
+func (o *scanOrder) At(i int) string {
+	return o.zsl.ByRank(i + 1).member
+}
+
+// randomSampleMul is how many times the count of a random sample a value must
+// hold for the sample to be picked index by index. Like in Redis, a sample of
+// a larger share of the value lists all of it and shuffles what it needs.
+const randomSampleMul = 3
+
+// randomSample returns count distinct members picked at random from a value
+// of n members, which it reads one at a time with at or, for a large share of
+// them, all at once with all. count must not exceed n.
+func randomSample(n, count int, at func(i int) string, all func() []string) []string {
+	if count*randomSampleMul > n {
+		members := all()
+		for i := range count {
+			j := i + rand.Intn(n-i)
+			members[i], members[j] = members[j], members[i]
+		}
+		return members[:count]
+	}
+	picked := make(map[int]struct{}, count)
+	sample := make([]string, 0, count)
+	for len(sample) < count {
+		i := rand.Intn(n)
+		if _, ok := picked[i]; !ok {
+			picked[i] = struct{}{}
+			sample = append(sample, at(i))
+		}
+	}
+	return sample
+}
+
+// Scan returns about count of members starting at cursor, filtered by the
+// MATCH pattern, and the cursor to continue from, 0 once done. Members whose
+// hashes collide are always returned together.
+func (o *scanOrder) Scan(opts scanOptions) (uint64, []string) {
+	x := o.zsl.FirstInRange(scoreRange{min: float64(opts.cursor), max: math.Inf(1)})
+	var batch []string
+	for n := 0; x != nil; x, n = x.level[0].forward, n+1 {
+		if n >= opts.count && x.score != x.backward.score {
+			break
+		}
+		if opts.match == "" || globMatch(opts.match, x.member) {
+			batch = append(batch, x.member)
+		}
+	}
+	if x == nil {
+		return 0, batch
+	}
+	return uint64(x.score), batch
+}
+
+// scanAll returns the members of a value too small to keep a scan order, all
+// in one call as Redis does for its compact encodings.
+func scanAll(members []string, opts scanOptions) (uint64, []string) {
+	batch := members[:0]
+	for _, m := range members {
+		if opts.match == "" || globMatch(opts.match, m) {
+			batch = append(batch, m)
+		}
+	}
+	return 0, batch
+}
+
+// respScan writes a SCAN style reply: the next cursor and the elements.
+func respScan(c *Client, cursor uint64, elems []string) error {
+	if err := respArrayLen(c, 2); err != nil {
+		return err
+	}
+	if err := respWriter(c, BULK, strconv.FormatUint(cursor, 10)); err != nil {
+		return err
+	}
+	return respArray(c, elems)
+}
+
+// globMatch reports whether s matches the glob-style pattern, with the
+// syntax of Redis: * and ? wildcards, [...] classes that may be negated
+// with ^ and contain ranges, and \ to escape the next character.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					match = match || pattern[0] == s[0]
+				case len(pattern) >= 3 && pattern[1] == '-':
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					match = match || (s[0] >= lo && s[0] <= hi)
+					pattern = pattern[2:]
+				default:
+					match = match || pattern[0] == s[0]
+				}
+				pattern = pattern[1:]
+			}
+			if match == not {
+				return false
+			}
+			s = s[1:]
+			if len(pattern) == 0 {
+				// Unterminated class: the pattern ends here.
+				return len(s) == 0
+			}
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
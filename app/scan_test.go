@@ -0,0 +1,32 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestRandomSample(t *testing.T) {
+	const n = 100
+	all := func() []string {
+		members := make([]string, n)
+		for i := range members {
+			members[i] = strconv.Itoa(i)
+		}
+		return members
+	}
+	// Counts up to n/randomSampleMul pick by index, larger ones shuffle.
+	for count := 0; count <= n; count++ {
+		sample := randomSample(n, count, strconv.Itoa, all)
+		sorted := slices.Clone(sample)
+		slices.Sort(sorted)
+		if len(slices.Compact(sorted)) != count {
+			t.Fatalf("sample of %d = %v", count, sample)
+		}
+		for _, m := range sample {
+			if i, err := strconv.Atoi(m); err != nil || i < 0 || i >= n {
+				t.Fatalf("sample of %d has %q", count, m)
+			}
+		}
+	}
+}
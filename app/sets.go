@@ -0,0 +1,219 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+func handleSadd(c *Client, args []string) error {
+	n, err := GlobalStore.SAdd(args[1], args[2:])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+func handleSrem(c *Client, args []string) error {
+	n, err := GlobalStore.SRem(args[1], args[2:])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+func handleSismember(c *Client, args []string) error {
+	set, err := GlobalStore.SetMembers(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if !set.Has(args[2]) {
+		return respWriter(c, INTEGER, "0")
+	}
+	return respWriter(c, INTEGER, "1")
+}
+
+func handleSmismember(c *Client, args []string) error {
+	set, err := GlobalStore.SetMembers(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if err := respArrayLen(c, len(args)-2); err != nil {
+		return err
+	}
+	for _, m := range args[2:] {
+		n := "0"
+		if set.Has(m) {
+			n = "1"
+		}
+		if err := respWriter(c, INTEGER, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleSmembers(c *Client, args []string) error {
+	set, err := GlobalStore.SetMembers(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respArray(c, set.Members())
+}
+
+func handleScard(c *Client, args []string) error {
+	set, err := GlobalStore.SetMembers(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(set.Len()))
+}
+
+// handleSpop implements SPOP key [count]. Without count it replies with a
+// single member.
+func handleSpop(c *Client, args []string) error {
+	if len(args) > 3 {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return respWriter(c, ERROR, "ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	members, err := GlobalStore.SPop(args[1], count)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if len(args) == 3 {
+		return respArray(c, members)
+	}
+	if len(members) == 0 {
+		_, err := c.Write([]byte("$-1\r\n"))
+		return err
+	}
+	return respWriter(c, BULK, members[0])
+}
+
+// handleSrandmember implements SRANDMEMBER key [count]. A positive count
+// returns distinct members, a negative one may repeat them.
+func handleSrandmember(c *Client, args []string) error {
+	if len(args) > 3 {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	set, err := GlobalStore.SetMembers(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if len(args) == 2 {
+		if set.Len() == 0 {
+			_, err := c.Write([]byte("$-1\r\n"))
+			return err
+		}
+		return respWriter(c, BULK, set.Random())
+	}
+	count, err := parseRandomCount(args[2], false)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if count >= 0 || set.Len() == 0 {
+		return respArray(c, set.Sample(max(count, 0)))
+	}
+	if err := checkRandomRepeats(count); err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if err := respArrayLen(c, -count); err != nil {
+		return err
+	}
+	for range -count {
+		if err := respWriter(c, BULK, set.Random()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleSmove(c *Client, args []string) error {
+	moved, err := GlobalStore.SMove(args[1], args[2], args[3])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if !moved {
+		return respWriter(c, INTEGER, "0")
+	}
+	return respWriter(c, INTEGER, "1")
+}
+
+// handleSetAlgebra implements SINTER, SUNION and SDIFF, and their STORE
+// variants, which take the destination key first and reply with the size
+// of the result.
+func handleSetAlgebra(c *Client, args []string) error {
+	name := strings.ToLower(args[0])
+	keys := args[1:]
+	store := strings.HasSuffix(name, "store")
+	if store {
+		keys = args[2:]
+	}
+	sets, err := GlobalStore.sets(keys)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	var result *memberSet
+	switch strings.TrimSuffix(name, "store") {
+	case "sinter":
+		result = setInter(sets, 0)
+	case "sunion":
+		result = setUnion(sets)
+	case "sdiff":
+		result = setDiff(sets)
+	}
+	if store {
+		GlobalStore.StoreSet(args[1], result)
+		return respWriter(c, INTEGER, strconv.Itoa(result.Len()))
+	}
+	return respArray(c, result.Members())
+}
+
+// handleSintercard implements SINTERCARD numkeys key [key ...]
+// [LIMIT limit].
+func handleSintercard(c *Client, args []string) error {
+	numkeys, err := strconv.Atoi(args[1])
+	if err != nil || numkeys <= 0 {
+		return respWriter(c, ERROR, "ERR numkeys should be greater than 0")
+	}
+	if numkeys > len(args)-2 {
+		return respWriter(c, ERROR, "ERR Number of keys can't be greater than number of args")
+	}
+	limit := 0
+	if rest := args[2+numkeys:]; len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(rest[0], "LIMIT") {
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+		if limit, err = strconv.Atoi(rest[1]); err != nil {
+			return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return respWriter(c, ERROR, "ERR LIMIT can't be negative")
+		}
+	}
+	sets, err := GlobalStore.sets(args[2 : 2+numkeys])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(setInter(sets, limit).Len()))
+}
+
+// handleSscan implements SSCAN key cursor [MATCH pattern] [COUNT count].
+func handleSscan(c *Client, args []string) error {
+	opts, err := parseScan(args[2:], false)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	set, err := GlobalStore.SetMembers(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	cursor, members := set.Scan(opts)
+	return respScan(c, cursor, members)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSrandmemberCount(t *testing.T) {
+	tc := newTestClient(t)
+	if _, err := tc.Do("sadd", "test:srand", "x", "y"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tc.Do("del", "test:srand") })
+	const n = 2_000_000
+	reply, err := tc.Do("srandmember", "test:srand", strconv.Itoa(-n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elems, _ := reply.([]any)
+	if len(elems) != n {
+		t.Fatalf("SRANDMEMBER -%d returned %d members", n, len(elems))
+	}
+	for _, m := range elems {
+		if m != "x" && m != "y" {
+			t.Fatalf("SRANDMEMBER returned %q", m)
+		}
+	}
+	if reply, _ := tc.Do("srandmember", "test:srand", strconv.Itoa(-maxRandomRepeats-1)); !isError(reply) {
+		t.Fatalf("count past maxRandomRepeats replied %v", reply)
+	}
+}
+
+func TestSetSample(t *testing.T) {
+	for _, prefix := range []string{"", "m"} {
+		// Integers keep the set an intset, a prefix makes it a hash table.
+		s := newMemberSet()
+		for i := range 300 {
+			s.Add(prefix + strconv.Itoa(i))
+		}
+		if s.isIntset() != (prefix == "") {
+			t.Fatalf("set of %q members has the wrong encoding", prefix)
+		}
+		for _, count := range []int{0, 1, 10, 99, 101, 299, 300, 400} {
+			sample := s.Sample(count)
+			seen := make(map[string]bool)
+			for _, m := range sample {
+				if !s.Has(m) || seen[m] {
+					t.Fatalf("sample of %d has %q twice or not in the set", count, m)
+				}
+				seen[m] = true
+			}
+			if len(sample) != min(count, s.Len()) {
+				t.Fatalf("sample of %d has %d members", count, len(sample))
+			}
+		}
+		if m := s.Random(); !s.Has(m) {
+			t.Fatalf("random member %q", m)
+		}
+	}
+}
+
+func TestSpopCount(t *testing.T) {
+	s := NewStore()
+	for i := range 200 {
+		s.SAdd("set", []string{strconv.Itoa(i)})
+	}
+	popped := make(map[string]bool)
+	for _, count := range []int{5, 60, 0, 200} {
+		members, err := s.SPop("set", count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range members {
+			if popped[m] {
+				t.Fatalf("%q popped twice", m)
+			}
+			popped[m] = true
+		}
+	}
+	if len(popped) != 200 {
+		t.Fatalf("popped %d members of 200", len(popped))
+	}
+	if set, _ := s.SetMembers("set"); set != nil {
+		t.Fatal("emptied set kept")
+	}
+}
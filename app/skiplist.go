@@ -0,0 +1,304 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+)
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+// zskiplist orders the members of a sorted set by score, then member, as in
+// Redis. Every link records how many nodes it spans, so the rank of a node
+// is found on the way to it and a node is found by rank in O(log n).
+type zskiplist struct {
+	header, tail *zskiplistNode
+	length       int
+	level        int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether n sorts before score and member.
+func (n *zskiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// Insert adds a node for member, which must not be in the list yet.
+func (zsl *zskiplist) Insert(score float64, member string) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// Delete removes the node of member with the given score and reports
+// whether there was one.
+func (zsl *zskiplist) Delete(score float64, member string) bool {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// Rank returns the 1-based rank of member with the given score, 0 if it is
+// not in the list.
+func (zsl *zskiplist) Rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil &&
+			(next.score < score || (next.score == score && next.member <= member)); next = x.level[i].forward {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// ByRank returns the node with the 1-based rank, nil if out of range.
+func (zsl *zskiplist) ByRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank && x != zsl.header {
+			return x
+		}
+	}
+	return nil
+}
+
+// scoreRange is a range of scores whose ends may be exclusive.
+type scoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r scoreRange) gteMin(v float64) bool {
+	if r.minex {
+		return v > r.min
+	}
+	return v >= r.min
+}
+
+func (r scoreRange) lteMax(v float64) bool {
+	if r.maxex {
+		return v < r.max
+	}
+	return v <= r.max
+}
+
+func (r scoreRange) empty() bool {
+	return r.min > r.max || (r.min == r.max && (r.minex || r.maxex))
+}
+
+// lexBound is an end of a range of members: - and + stand for the smallest
+// and the largest possible member.
+type lexBound struct {
+	member    string
+	exclusive bool
+	inf       int
+}
+
+func (b lexBound) compare(other lexBound) int {
+	switch {
+	case b.inf == other.inf && b.inf != 0:
+		return 0
+	case b.inf < 0 || other.inf > 0:
+		return -1
+	case b.inf > 0 || other.inf < 0:
+		return 1
+	}
+	return strings.Compare(b.member, other.member)
+}
+
+// lexRange is a range of members of a sorted set whose members all have the
+// same score.
+type lexRange struct {
+	min, max lexBound
+}
+
+func (r lexRange) gteMin(m string) bool {
+	switch {
+	case r.min.inf != 0:
+		return r.min.inf < 0
+	case r.min.exclusive:
+		return m > r.min.member
+	}
+	return m >= r.min.member
+}
+
+func (r lexRange) lteMax(m string) bool {
+	switch {
+	case r.max.inf != 0:
+		return r.max.inf > 0
+	case r.max.exclusive:
+		return m < r.max.member
+	}
+	return m <= r.max.member
+}
+
+func (r lexRange) empty() bool {
+	cmp := r.min.compare(r.max)
+	return cmp > 0 || (cmp == 0 && (r.min.exclusive || r.max.exclusive))
+}
+
+// FirstInRange returns the first node whose score is in r, nil if none.
+func (zsl *zskiplist) FirstInRange(r scoreRange) *zskiplistNode {
+	return zslFirst(zsl, r.empty(), r.gteMin, r.lteMax, nodeScore)
+}
+
+// LastInRange returns the last node whose score is in r, nil if none.
+func (zsl *zskiplist) LastInRange(r scoreRange) *zskiplistNode {
+	return zslLast(zsl, r.empty(), r.gteMin, r.lteMax, nodeScore)
+}
+
+// FirstInLexRange returns the first node whose member is in r, nil if none.
+func (zsl *zskiplist) FirstInLexRange(r lexRange) *zskiplistNode {
+	return zslFirst(zsl, r.empty(), r.gteMin, r.lteMax, nodeMember)
+}
+
+// LastInLexRange returns the last node whose member is in r, nil if none.
+func (zsl *zskiplist) LastInLexRange(r lexRange) *zskiplistNode {
+	return zslLast(zsl, r.empty(), r.gteMin, r.lteMax, nodeMember)
+}
+
+func nodeScore(n *zskiplistNode) float64 { return n.score }
+
+func nodeMember(n *zskiplistNode) string { return n.member }
+
+// zslFirst returns the first node whose key, as extracted by key, is within
+// the bounds checked by gteMin and lteMax.
+func zslFirst[K any](zsl *zskiplist, empty bool, gteMin, lteMax func(K) bool, key func(*zskiplistNode) K) *zskiplistNode {
+	first := zsl.header.level[0].forward
+	if empty || first == nil || !gteMin(key(zsl.tail)) || !lteMax(key(first)) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !gteMin(key(x.level[i].forward)) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if !lteMax(key(x)) {
+		return nil
+	}
+	return x
+}
+
+// zslLast returns the last node whose key is within the bounds.
+func zslLast[K any](zsl *zskiplist, empty bool, gteMin, lteMax func(K) bool, key func(*zskiplistNode) K) *zskiplistNode {
+	first := zsl.header.level[0].forward
+	if empty || first == nil || !gteMin(key(zsl.tail)) || !lteMax(key(first)) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && lteMax(key(x.level[i].forward)) {
+			x = x.level[i].forward
+		}
+	}
+	if !gteMin(key(x)) {
+		return nil
+	}
+	return x
+}
@@ -1,148 +1,1385 @@
 package main
 
 import (
-	"fmt"
+	"errors"
+	"math"
 	"slices"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// ValueType is the type of the value held by a key, as reported by TYPE.
+type ValueType string
+
+const (
+	StringType ValueType = "string"
+	ListType   ValueType = "list"
+	HashType   ValueType = "hash"
+	SetType    ValueType = "set"
+	ZSetType   ValueType = "zset"
+	StreamType ValueType = "stream"
+)
+
+var (
+	errWrongType       = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNoSuchKey       = errors.New("ERR no such key")
+	errIndexOutOfRange = errors.New("ERR index out of range")
+	errHashNotInteger  = errors.New("ERR hash value is not an integer")
+	errHashNotFloat    = errors.New("ERR hash value is not a float")
+	errOverflow        = errors.New("ERR increment or decrement would overflow")
+	errNaNOrInfinity   = errors.New("ERR increment would produce NaN or Infinity")
+	errNaNScore        = errors.New("ERR resulting score is not a number (NaN)")
+)
+
+// StoreValue is a value in the keyspace. According to kind, value holds a
+// string (or a []byte once modified by bit operations), a *quicklist for
+// lists, a *hashValue for hashes, a *memberSet for sets, a *sortedSet
+// for sorted sets or a *stream for streams.
 type StoreValue struct {
-	value     string
-	expiresAt time.Time
+	kind  ValueType
+	value any
 }
 
+// StreamEntry is an entry of a stream, with its field names and values in
+// the order they were given.
 type StreamEntry struct {
-	ID     string
-	mu     *sync.RWMutex
-	Fields map[string]string
+	ID     streamID
+	Fields []string
 }
 
+// Store is the keyspace. Its methods do no locking of their own: every access
+// must happen with mu held. Connections hold it for the whole execution of a
+// command or transaction and the active expire cycle for one round, so
+// commands run one at a time as if on a single thread, like in Redis.
+// Blocking commands release it while they wait, see Unlocked.
 type Store struct {
-	data            map[string]StoreValue
-	lists           map[string][]string
-	mutList         map[string]*sync.RWMutex
-	mutStream       map[string]*sync.RWMutex
-	blockedChannels map[string][]chan string
-	streams         map[string][]StreamEntry
+	mu         sync.Mutex
+	data       map[string]*StoreValue
+	expires    map[string]time.Time
+	blocked    map[string][]*blockedClient
+	readyKeys  map[string]bool
+	readyQueue []string
+	watched    map[string]*watchedKey
+	stats      ExpireStats
+}
+
+// watchedKey counts modifications of a key that at least one client WATCHes.
+// Keys nobody watches are not tracked, so the map does not grow with the
+// keyspace.
+type watchedKey struct {
+	watchers int
+	version  uint64
 }
 
 func NewStore() *Store {
 	return &Store{
-		data:            make(map[string]StoreValue),
-		lists:           make(map[string][]string),
-		mutList:         make(map[string]*sync.RWMutex),
-		mutStream:       make(map[string]*sync.RWMutex),
-		blockedChannels: make(map[string][]chan string),
-		streams:         make(map[string][]StreamEntry),
+		data:      make(map[string]*StoreValue),
+		expires:   make(map[string]time.Time),
+		blocked:   make(map[string][]*blockedClient),
+		readyKeys: make(map[string]bool),
+		watched:   make(map[string]*watchedKey),
+	}
+}
+
+// Unlocked runs f with the store lock released. Blocking commands use it
+// while they wait, so other clients can keep running commands.
+func (s *Store) Unlocked(f func()) {
+	s.mu.Unlock()
+	defer s.mu.Lock()
+	f()
+}
+
+// touch bumps the version of key. Every method that modifies, deletes or
+// expires a key must call it so that WATCH notices the change.
+func (s *Store) touch(key string) {
+	if w, ok := s.watched[key]; ok {
+		w.version++
+	}
+}
+
+// Watch starts tracking key and returns its current version.
+func (s *Store) Watch(key string) uint64 {
+	w, ok := s.watched[key]
+	if !ok {
+		w = &watchedKey{}
+		s.watched[key] = w
+	}
+	w.watchers++
+	return w.version
+}
+
+func (s *Store) Unwatch(key string) {
+	w, ok := s.watched[key]
+	if !ok {
+		return
+	}
+	w.watchers--
+	if w.watchers == 0 {
+		delete(s.watched, key)
+	}
+}
+
+func (s *Store) KeyVersion(key string) uint64 {
+	if w, ok := s.watched[key]; ok {
+		return w.version
 	}
+	return 0
 }
 
-func (s *Store) Set(key string, value StoreValue) {
-	s.data[key] = StoreValue{value: value.value, expiresAt: value.expiresAt}
+// IsExpired reports whether key still exists but its TTL has passed.
+func (s *Store) IsExpired(key string) bool {
+	expiresAt, ok := s.expires[key]
+	return ok && time.Now().After(expiresAt)
 }
 
-func (s *Store) Get(key string) (StoreValue, bool) {
+// lookup returns the value at key. An expired key is deleted on access and
+// reported as missing.
+func (s *Store) lookup(key string) (*StoreValue, bool) {
+	if s.IsExpired(key) {
+		s.expire(key)
+		return nil, false
+	}
 	val, ok := s.data[key]
 	return val, ok
 }
 
-func (s *Store) GetListMutex(key string) *sync.RWMutex {
-	if _, ok := s.mutList[key]; !ok {
-		s.mutList[key] = &sync.RWMutex{}
+// lookupKind is lookup for commands that only work on one type of value.
+func (s *Store) lookupKind(key string, kind ValueType) (*StoreValue, bool, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if val.kind != kind {
+		return nil, false, errWrongType
+	}
+	return val, true, nil
+}
+
+// Delete removes key and reports whether it existed.
+func (s *Store) Delete(key string) bool {
+	if _, ok := s.data[key]; !ok {
+		return false
+	}
+	s.remove(key)
+	s.touch(key)
+	return true
+}
+
+// remove drops key and its TTL without notifying watchers. Clients blocked
+// on key are signalled, so that one waiting on what the old value held, such
+// as a consumer group, finds out that it is gone.
+func (s *Store) remove(key string) {
+	if _, ok := s.data[key]; ok {
+		s.signalKeyReady(key)
+	}
+	delete(s.data, key)
+	delete(s.expires, key)
+}
+
+// KeyCount returns the number of keys and the number of keys with a TTL.
+// Keys that expired but were not deleted yet are included.
+func (s *Store) KeyCount() (keys, volatile int) {
+	return len(s.data), len(s.expires)
+}
+
+func (s *Store) Exists(key string) bool {
+	_, ok := s.lookup(key)
+	return ok
+}
+
+// Rename moves the value at src, with its TTL, to dst, replacing whatever
+// dst held. It reports false if src does not exist.
+func (s *Store) Rename(src, dst string) bool {
+	val, ok := s.lookup(src)
+	if !ok {
+		return false
+	}
+	if src == dst {
+		return true
+	}
+	expiresAt, volatile := s.expires[src]
+	s.remove(src)
+	s.touch(src)
+	s.remove(dst)
+	s.data[dst] = val
+	if volatile {
+		s.expires[dst] = expiresAt
+	}
+	s.touch(dst)
+	s.signalKeyReady(dst)
+	return true
+}
+
+// Copy stores a copy of the value at src, with its TTL, at dst. Unless
+// replace is set nothing is copied when dst already exists. It reports
+// whether the copy was made.
+func (s *Store) Copy(src, dst string, replace bool) bool {
+	val, ok := s.lookup(src)
+	if !ok {
+		return false
+	}
+	if s.Exists(dst) && !replace {
+		return false
+	}
+	s.remove(dst)
+	s.data[dst] = val.clone()
+	if expiresAt, ok := s.expires[src]; ok {
+		s.expires[dst] = expiresAt
+	}
+	s.touch(dst)
+	s.signalKeyReady(dst)
+	return true
+}
+
+// clone returns a copy of v that shares no mutable state with it.
+func (v *StoreValue) clone() *StoreValue {
+	c := *v
+	switch value := v.value.(type) {
+	case []byte:
+		c.value = slices.Clone(value)
+	case *quicklist:
+		c.value = value.Clone()
+	case *hashValue:
+		c.value = value.Clone()
+	case *memberSet:
+		c.value = value.Clone()
+	case *sortedSet:
+		c.value = value.Clone()
+	case *stream:
+		c.value = value.Clone()
+	}
+	return &c
+}
+
+// Type returns the type of the value at key, or "none" if there is none.
+func (s *Store) Type(key string) string {
+	val, ok := s.lookup(key)
+	if !ok {
+		return "none"
+	}
+	return string(val.kind)
+}
+
+// Set stores a string at key, replacing any value of any type. The key
+// expires at expiresAt, or never if it is zero.
+func (s *Store) Set(key, value string, expiresAt time.Time) {
+	s.touch(key)
+	s.remove(key)
+	s.data[key] = &StoreValue{kind: StringType, value: value}
+	if !expiresAt.IsZero() {
+		s.expires[key] = expiresAt
+	}
+}
+
+func (s *Store) Get(key string) (string, bool, error) {
+	val, ok, err := s.lookupKind(key, StringType)
+	if !ok {
+		return "", false, err
+	}
+	if b, ok := val.value.([]byte); ok {
+		return string(b), true, nil
+	}
+	return val.value.(string), true, nil
+}
+
+// stringBytes returns the string at key as bytes, nil if there is none. The
+// caller must not modify them.
+func (s *Store) stringBytes(key string) ([]byte, error) {
+	val, ok, err := s.lookupKind(key, StringType)
+	if !ok {
+		return nil, err
+	}
+	if b, ok := val.value.([]byte); ok {
+		return b, nil
+	}
+	return []byte(val.value.(string)), nil
+}
+
+// bytesForWrite returns the string at key as bytes to be modified in place,
+// padded with zero bytes to at least size. A missing key is created. Strings
+// modified by bit operations stay stored as bytes, so that setting a bit of
+// a large bitmap does not copy it. This counts as a modification of the key.
+func (s *Store) bytesForWrite(key string, size int) ([]byte, error) {
+	val, ok, err := s.lookupKind(key, StringType)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		val = &StoreValue{kind: StringType, value: []byte{}}
+		s.data[key] = val
+	}
+	b, isBytes := val.value.([]byte)
+	if !isBytes {
+		b = []byte(val.value.(string))
+	}
+	if len(b) < size {
+		b = append(b, make([]byte, size-len(b))...)
+	}
+	val.value = b
+	s.touch(key)
+	return b, nil
+}
+
+// ExpiresAt returns the expiry time of key, zero if it has none.
+func (s *Store) ExpiresAt(key string) time.Time {
+	if !s.Exists(key) {
+		return time.Time{}
+	}
+	return s.expires[key]
+}
+
+// SetExpire makes key expire at expiresAt. A time that already passed deletes
+// the key right away. It reports false if the key does not exist.
+func (s *Store) SetExpire(key string, expiresAt time.Time) bool {
+	if !s.Exists(key) {
+		return false
+	}
+	if !time.Now().Before(expiresAt) {
+		return s.Delete(key)
+	}
+	s.expires[key] = expiresAt
+	s.touch(key)
+	return true
+}
+
+// Persist removes the TTL of key and reports whether it had one.
+func (s *Store) Persist(key string) bool {
+	if !s.Exists(key) {
+		return false
+	}
+	if _, ok := s.expires[key]; !ok {
+		return false
 	}
-	return s.mutList[key]
+	delete(s.expires, key)
+	s.touch(key)
+	return true
 }
 
-func (s *Store) Rpush(key string, value []string) {
-	val, ok := s.lists[key]
-	mutex := s.GetListMutex(key)
-	mutex.Lock()
-	defer mutex.Unlock()
-	if ok {
-		s.lists[key] = append(val, value...)
+// list returns the list at key, nil if there is none.
+func (s *Store) list(key string) (*quicklist, error) {
+	val, ok, err := s.lookupKind(key, ListType)
+	if !ok {
+		return nil, err
+	}
+	return val.value.(*quicklist), nil
+}
+
+// listForPush returns the list at key, creating an empty one if there is
+// none. The caller must add elements to it.
+func (s *Store) listForPush(key string) (*quicklist, error) {
+	list, err := s.list(key)
+	if list != nil || err != nil {
+		return list, err
+	}
+	list = newQuicklist()
+	s.data[key] = &StoreValue{kind: ListType, value: list}
+	return list, nil
+}
+
+// listChanged must be called after the list at key was modified. A list left
+// empty is deleted, as lists never exist without elements.
+func (s *Store) listChanged(key string, list *quicklist) {
+	s.touch(key)
+	if list.Len() == 0 {
+		s.remove(key)
+	}
+}
+
+func (s *Store) LLen(key string) (int, error) {
+	list, err := s.list(key)
+	return list.Len(), err
+}
+
+func (s *Store) Rpush(key string, value []string) (int, error) {
+	list, err := s.listForPush(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range value {
+		list.PushTail(v)
+	}
+	s.listChanged(key, list)
+	s.signalKeyReady(key)
+	return list.Len(), nil
+}
+
+func (s *Store) Lpush(key string, value []string) (int, error) {
+	list, err := s.listForPush(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range value {
+		list.PushHead(v)
+	}
+	s.listChanged(key, list)
+	s.signalKeyReady(key)
+	return list.Len(), nil
+}
+
+// LRange returns the elements from start to stop, which must be clamped to
+// the list already, see listRange.
+func (s *Store) LRange(key string, start, stop int) ([]string, error) {
+	list, err := s.list(key)
+	if err != nil {
+		return nil, err
+	}
+	if start > stop || start >= list.Len() {
+		return []string{}, nil
+	}
+	return list.Range(start, min(stop, list.Len()-1)), nil
+}
+
+func (s *Store) LPop(key string) (string, bool, error) {
+	list, err := s.list(key)
+	if list.Len() == 0 {
+		return "", false, err
+	}
+	val, _ := list.PopHead()
+	s.listChanged(key, list)
+	return val, true, nil
+}
+
+func (s *Store) RPop(key string) (string, bool, error) {
+	list, err := s.list(key)
+	if list.Len() == 0 {
+		return "", false, err
+	}
+	val, _ := list.PopTail()
+	s.listChanged(key, list)
+	return val, true, nil
+}
+
+// LPopMultiple pops up to num elements from the head of the list at key. It
+// returns nil if there is no list at key.
+func (s *Store) LPopMultiple(key string, num int) ([]string, error) {
+	list, err := s.list(key)
+	if list == nil {
+		return nil, err
+	}
+	num = min(num, list.Len())
+	popped := make([]string, num)
+	for i := range popped {
+		popped[i], _ = list.PopHead()
+	}
+	if num > 0 {
+		s.listChanged(key, list)
+	}
+	return popped, nil
+}
+
+// RPopMultiple pops up to num elements from the tail of the list at key, the
+// last element first. It returns nil if there is no list at key.
+func (s *Store) RPopMultiple(key string, num int) ([]string, error) {
+	list, err := s.list(key)
+	if list == nil {
+		return nil, err
+	}
+	num = min(num, list.Len())
+	popped := make([]string, num)
+	for i := range popped {
+		popped[i], _ = list.PopTail()
+	}
+	if num > 0 {
+		s.listChanged(key, list)
+	}
+	return popped, nil
+}
+
+// listIndex turns index, which may count from the end if negative, into a
+// position in a list of n elements. ok is false if it is out of range.
+func listIndex(index, n int) (i int, ok bool) {
+	if index < 0 {
+		index += n
+	}
+	return index, index >= 0 && index < n
+}
+
+// listRange clamps the LRANGE style range start..stop, whose ends may count
+// from the end if negative, to a list of n elements. The range is empty if
+// start > stop.
+func listRange(start, stop, n int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	return max(start, 0), min(stop, n-1)
+}
+
+func (s *Store) LIndex(key string, index int) (string, bool, error) {
+	list, err := s.list(key)
+	if err != nil {
+		return "", false, err
+	}
+	i, ok := listIndex(index, list.Len())
+	if !ok {
+		return "", false, nil
+	}
+	return list.Index(i), true, nil
+}
+
+func (s *Store) LSet(key string, index int, value string) error {
+	list, err := s.list(key)
+	if err != nil {
+		return err
+	}
+	if list == nil {
+		return errNoSuchKey
+	}
+	i, ok := listIndex(index, list.Len())
+	if !ok {
+		return errIndexOutOfRange
+	}
+	list.Set(i, value)
+	s.touch(key)
+	return nil
+}
+
+// LInsert inserts value before or after the first occurrence of pivot and
+// returns the new length, 0 if the key does not exist and -1 if pivot was not
+// found.
+func (s *Store) LInsert(key string, after bool, pivot, value string) (int, error) {
+	list, err := s.list(key)
+	if list == nil {
+		return 0, err
+	}
+	i := -1
+	list.Each(false, func(j int, v string) bool {
+		if v == pivot {
+			i = j
+			return false
+		}
+		return true
+	})
+	if i < 0 {
+		return -1, nil
+	}
+	if after {
+		i++
+	}
+	list.Insert(i, value)
+	s.listChanged(key, list)
+	return list.Len(), nil
+}
+
+// LRem removes the first count occurrences of value, searching from the tail
+// if count is negative and removing all of them if it is 0. It returns the
+// number of elements removed.
+func (s *Store) LRem(key string, count int, value string) (int, error) {
+	list, err := s.list(key)
+	if list == nil {
+		return 0, err
+	}
+	limit := count
+	if count < 0 {
+		limit = -count
+	}
+	kept := newQuicklist()
+	removed := 0
+	list.Each(count < 0, func(_ int, v string) bool {
+		if v == value && (limit == 0 || removed < limit) {
+			removed++
+		} else if count < 0 {
+			kept.PushHead(v)
+		} else {
+			kept.PushTail(v)
+		}
+		return true
+	})
+	if removed == 0 {
+		return 0, nil
+	}
+	s.data[key].value = kept
+	s.listChanged(key, kept)
+	return removed, nil
+}
+
+// LTrim keeps only the elements from start to stop, as given to LRANGE.
+func (s *Store) LTrim(key string, start, stop int) error {
+	list, err := s.list(key)
+	if list == nil {
+		return err
+	}
+	n := list.Len()
+	start, stop = listRange(start, stop, n)
+	if start > stop {
+		list.DropHead(n)
 	} else {
-		s.lists[key] = value
+		list.DropTail(n - 1 - stop)
+		list.DropHead(start)
 	}
+	s.listChanged(key, list)
+	return nil
 }
 
-func (s *Store) Lpush(key string, value []string) {
-	val, ok := s.lists[key]
-	mutex := s.GetListMutex(key)
-	mutex.Lock()
-	defer mutex.Unlock()
-	if ok {
-		slices.Reverse(value)
-		s.lists[key] = append(value, val...)
+// LPos returns the positions of the elements equal to value. It skips the
+// first rank-1 matches, scanning from the tail if rank is negative, and stops
+// after count matches (unless count is 0) or after comparing maxlen elements
+// (unless maxlen is 0).
+func (s *Store) LPos(key, value string, rank, count, maxlen int) ([]int, error) {
+	list, err := s.list(key)
+	if err != nil {
+		return nil, err
+	}
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	positions := []int{}
+	compared := 0
+	list.Each(rank < 0, func(i int, v string) bool {
+		if maxlen > 0 && compared == maxlen {
+			return false
+		}
+		compared++
+		if v != value {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		positions = append(positions, i)
+		return count == 0 || len(positions) < count
+	})
+	return positions, nil
+}
+
+// LMove pops an element from one end of the list at src and pushes it to one
+// end of the list at dst. ok is false if src is empty.
+func (s *Store) LMove(src, dst string, srcLeft, dstLeft bool) (string, bool, error) {
+	list, err := s.list(src)
+	if list == nil {
+		return "", false, err
+	}
+	if _, err := s.list(dst); err != nil {
+		return "", false, err
+	}
+	var val string
+	if srcLeft {
+		val, _, _ = s.LPop(src)
+	} else {
+		val, _, _ = s.RPop(src)
+	}
+	if dstLeft {
+		s.Lpush(dst, []string{val})
 	} else {
-		s.lists[key] = value
+		s.Rpush(dst, []string{val})
+	}
+	return val, true, nil
+}
+
+// Hash returns the hash at key, nil if there is none. The caller must not
+// modify it.
+func (s *Store) Hash(key string) (*hashValue, error) {
+	val, ok, err := s.lookupKind(key, HashType)
+	if !ok {
+		return nil, err
 	}
+	return val.value.(*hashValue), nil
 }
 
-func (s *Store) LRange(key string, start, stop int) []string {
-	mutex := s.GetListMutex(key)
-	mutex.RLock()
-	defer mutex.RUnlock()
-	if start >= len(s.lists[key]) {
-		return []string{}
+// hashForWrite returns the hash at key, creating an empty one if there is
+// none. The caller must call hashChanged once done with it.
+func (s *Store) hashForWrite(key string) (*hashValue, error) {
+	hash, err := s.Hash(key)
+	if hash != nil || err != nil {
+		return hash, err
 	}
-	return s.lists[key][start:min(stop+1, len(s.lists[key]))]
+	hash = newHashValue()
+	s.data[key] = &StoreValue{kind: HashType, value: hash}
+	return hash, nil
 }
 
-func (s *Store) LPop(key string) string {
-	mutex := s.GetListMutex(key)
-	mutex.Lock()
-	defer mutex.Unlock()
-	val, ok := s.lists[key]
+// hashChanged must be called after the hash at key was modified. A hash left
+// empty is deleted.
+func (s *Store) hashChanged(key string, hash *hashValue) {
+	s.touch(key)
+	if hash.Len() == 0 {
+		s.remove(key)
+	}
+}
+
+// HSet sets the fields of the hash at key from a list of field value pairs
+// and returns the number of fields that were added.
+func (s *Store) HSet(key string, pairs []string) (int, error) {
+	hash, err := s.hashForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for i := 0; i < len(pairs); i += 2 {
+		if hash.Set(pairs[i], pairs[i+1]) {
+			added++
+		}
+	}
+	s.hashChanged(key, hash)
+	return added, nil
+}
+
+// HSetNX sets field only if it does not exist yet and reports whether it did.
+func (s *Store) HSetNX(key, field, value string) (bool, error) {
+	hash, err := s.hashForWrite(key)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := hash.Get(field); ok {
+		return false, nil
+	}
+	hash.Set(field, value)
+	s.hashChanged(key, hash)
+	return true, nil
+}
+
+func (s *Store) HGet(key, field string) (string, bool, error) {
+	hash, err := s.Hash(key)
+	if err != nil {
+		return "", false, err
+	}
+	val, ok := hash.Get(field)
+	return val, ok, nil
+}
+
+// HDel removes fields from the hash at key and returns how many existed.
+func (s *Store) HDel(key string, fields []string) (int, error) {
+	hash, err := s.Hash(key)
+	if hash == nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, field := range fields {
+		if hash.Delete(field) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		s.hashChanged(key, hash)
+	}
+	return deleted, nil
+}
+
+// HIncrBy adds incr to the integer in field, a missing field counting as 0.
+func (s *Store) HIncrBy(key, field string, incr int64) (int64, error) {
+	hash, err := s.Hash(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if val, ok := hash.Get(field); ok {
+		if n, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return 0, errHashNotInteger
+		}
+	}
+	if (incr > 0 && n > math.MaxInt64-incr) || (incr < 0 && n < math.MinInt64-incr) {
+		return 0, errOverflow
+	}
+	n += incr
+	hash, _ = s.hashForWrite(key)
+	hash.Set(field, strconv.FormatInt(n, 10))
+	s.hashChanged(key, hash)
+	return n, nil
+}
+
+// HIncrByFloat adds incr to the number in field, a missing field counting as
+// 0, and returns the new value as stored.
+func (s *Store) HIncrByFloat(key, field string, incr float64) (string, error) {
+	hash, err := s.Hash(key)
+	if err != nil {
+		return "", err
+	}
+	var n float64
+	if val, ok := hash.Get(field); ok {
+		if n, err = strconv.ParseFloat(val, 64); err != nil || math.IsNaN(n) {
+			return "", errHashNotFloat
+		}
+	}
+	n += incr
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return "", errNaNOrInfinity
+	}
+	val := strconv.FormatFloat(n, 'f', -1, 64)
+	hash, _ = s.hashForWrite(key)
+	hash.Set(field, val)
+	s.hashChanged(key, hash)
+	return val, nil
+}
+
+// SetMembers returns the set at key, nil if there is none. The caller must
+// not modify it.
+func (s *Store) SetMembers(key string) (*memberSet, error) {
+	val, ok, err := s.lookupKind(key, SetType)
 	if !ok {
-		return ""
-	} else {
-		value := val[0]
-		s.lists[key] = val[1:]
-		return value
+		return nil, err
+	}
+	return val.value.(*memberSet), nil
+}
+
+// sets returns the sets at keys, nil for missing ones. It fails if any key
+// holds another type.
+func (s *Store) sets(keys []string) ([]*memberSet, error) {
+	sets := make([]*memberSet, len(keys))
+	for i, key := range keys {
+		set, err := s.SetMembers(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
+// setForWrite returns the set at key, creating an empty one if there is
+// none. The caller must call setChanged once done with it.
+func (s *Store) setForWrite(key string) (*memberSet, error) {
+	set, err := s.SetMembers(key)
+	if set != nil || err != nil {
+		return set, err
+	}
+	set = newMemberSet()
+	s.data[key] = &StoreValue{kind: SetType, value: set}
+	return set, nil
+}
+
+// setChanged must be called after the set at key was modified. A set left
+// empty is deleted.
+func (s *Store) setChanged(key string, set *memberSet) {
+	s.touch(key)
+	if set.Len() == 0 {
+		s.remove(key)
 	}
 }
 
-func (s *Store) LPopMultiple(key string, num int) []string {
-	mutex := s.GetListMutex(key)
-	mutex.Lock()
-	defer mutex.Unlock()
-	val, ok := s.lists[key]
+// SAdd adds members to the set at key and returns how many were new.
+func (s *Store) SAdd(key string, members []string) (int, error) {
+	set, err := s.setForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, m := range members {
+		if set.Add(m) {
+			added++
+		}
+	}
+	s.setChanged(key, set)
+	return added, nil
+}
+
+// SRem removes members from the set at key and returns how many existed.
+func (s *Store) SRem(key string, members []string) (int, error) {
+	set, err := s.SetMembers(key)
+	if set == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, m := range members {
+		if set.Remove(m) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.setChanged(key, set)
+	}
+	return removed, nil
+}
+
+// SPop removes and returns up to count random members of the set at key.
+func (s *Store) SPop(key string, count int) ([]string, error) {
+	set, err := s.SetMembers(key)
+	if set == nil {
+		return nil, err
+	}
+	members := set.Sample(count)
+	for _, m := range members {
+		set.Remove(m)
+	}
+	if len(members) > 0 {
+		s.setChanged(key, set)
+	}
+	return members, nil
+}
+
+// SMove moves member from the set at src to the set at dst and reports
+// whether it was a member of src.
+func (s *Store) SMove(src, dst, member string) (bool, error) {
+	from, err := s.SetMembers(src)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.SetMembers(dst); err != nil {
+		return false, err
+	}
+	if !from.Has(member) {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+	from.Remove(member)
+	s.setChanged(src, from)
+	to, _ := s.setForWrite(dst)
+	to.Add(member)
+	s.setChanged(dst, to)
+	return true, nil
+}
+
+// StoreSet stores set at key, replacing whatever key held, or deletes key if
+// the set is empty.
+func (s *Store) StoreSet(key string, set *memberSet) {
+	s.remove(key)
+	if set.Len() > 0 {
+		s.data[key] = &StoreValue{kind: SetType, value: set}
+	}
+	s.touch(key)
+}
+
+// ZSet returns the sorted set at key, nil if there is none. The caller must
+// not modify it.
+func (s *Store) ZSet(key string) (*sortedSet, error) {
+	val, ok, err := s.lookupKind(key, ZSetType)
 	if !ok {
-		return []string{}
+		return nil, err
+	}
+	return val.value.(*sortedSet), nil
+}
+
+// zaddFlags are the conditions of ZADD: only add new members (nx), only
+// update existing ones (xx), only raise (gt) or only lower (lt) scores.
+type zaddFlags struct {
+	nx, xx, gt, lt bool
+}
+
+// allows reports whether the flags let a member at cur (if exists) move to
+// score.
+func (f zaddFlags) allows(exists bool, cur, score float64) bool {
+	if exists {
+		return !f.nx && !(f.gt && score <= cur) && !(f.lt && score >= cur)
+	}
+	return !f.xx
+}
+
+// ZAdd sets the scores of members as far as flags allow and returns the
+// number of members added and the number whose score changed.
+func (s *Store) ZAdd(key string, flags zaddFlags, scores []float64, members []string) (added, updated int, err error) {
+	zset, err := s.ZSet(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i, member := range members {
+		cur, exists := zset.Score(member)
+		if !flags.allows(exists, cur, scores[i]) || (exists && cur == scores[i]) {
+			continue
+		}
+		if zset == nil {
+			zset = newSortedSet()
+			s.data[key] = &StoreValue{kind: ZSetType, value: zset}
+		}
+		zset.Set(member, scores[i])
+		if exists {
+			updated++
+		} else {
+			added++
+		}
+	}
+	if added+updated > 0 {
+		s.touch(key)
+	}
+	return added, updated, nil
+}
+
+// ZIncrBy adds incr to the score of member, a missing member counting as 0,
+// as far as flags allow. ok is false if they did not.
+func (s *Store) ZIncrBy(key string, flags zaddFlags, incr float64, member string) (score float64, ok bool, err error) {
+	zset, err := s.ZSet(key)
+	if err != nil {
+		return 0, false, err
+	}
+	cur, exists := zset.Score(member)
+	score = cur + incr
+	if math.IsNaN(score) {
+		return 0, false, errNaNScore
+	}
+	if !flags.allows(exists, cur, score) {
+		return 0, false, nil
+	}
+	if zset == nil {
+		zset = newSortedSet()
+		s.data[key] = &StoreValue{kind: ZSetType, value: zset}
+	}
+	zset.Set(member, score)
+	s.touch(key)
+	return score, true, nil
+}
+
+// ZRem removes members from the sorted set at key and returns how many
+// existed.
+func (s *Store) ZRem(key string, members []string) (int, error) {
+	zset, err := s.ZSet(key)
+	if zset == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if zset.Remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.touch(key)
+		if zset.Len() == 0 {
+			s.remove(key)
+		}
+	}
+	return removed, nil
+}
+
+// hll returns the HyperLogLog at key, nil if there is none.
+func (s *Store) hll(key string) ([]byte, error) {
+	val, ok, err := s.Get(key)
+	if !ok {
+		return nil, err
+	}
+	if !isHLL([]byte(val)) {
+		return nil, errNotHLL
+	}
+	return []byte(val), nil
+}
+
+// setHLL stores the HyperLogLog b at key, keeping the TTL of the key.
+func (s *Store) setHLL(key string, b []byte) {
+	if val, ok := s.data[key]; ok {
+		val.value = string(b)
 	} else {
-		values := val[:num]
-		s.lists[key] = val[num:]
-		return values
+		s.data[key] = &StoreValue{kind: StringType, value: string(b)}
+	}
+	s.touch(key)
+}
+
+// PFAdd adds elems to the HyperLogLog at key, creating it if needed. It
+// reports whether the estimated cardinality may have changed.
+func (s *Store) PFAdd(key string, elems []string) (bool, error) {
+	b, err := s.hll(key)
+	if err != nil {
+		return false, err
+	}
+	created := b == nil
+	if created {
+		b = newHLL()
+	}
+	b, changed, err := hllAdd(b, elems)
+	if err != nil {
+		return false, err
+	}
+	if created || changed {
+		s.setHLL(key, b)
+	}
+	return created || changed, nil
+}
+
+// PFCount estimates the number of distinct elements added to the
+// HyperLogLogs at keys. The count of a single key is cached in its header,
+// which does not count as a modification of the key.
+func (s *Store) PFCount(keys []string) (uint64, error) {
+	if len(keys) == 1 {
+		b, err := s.hll(keys[0])
+		if b == nil {
+			return 0, err
+		}
+		if card, ok := hllCachedCount(b); ok {
+			return card, nil
+		}
+		regs, err := hllDecode(b)
+		if err != nil {
+			return 0, err
+		}
+		card := hllCount(regs)
+		hllSetCachedCount(b, card)
+		s.data[keys[0]].value = string(b)
+		return card, nil
+	}
+	regs, _, err := s.hllUnion(keys)
+	if err != nil {
+		return 0, err
+	}
+	return hllCount(regs), nil
+}
+
+// PFMerge stores the union of the HyperLogLogs at srcs and dst at dst. The
+// result is dense if any of them is.
+func (s *Store) PFMerge(dst string, srcs []string) error {
+	regs, dense, err := s.hllUnion(append([]string{dst}, srcs...))
+	if err != nil {
+		return err
+	}
+	s.setHLL(dst, hllEncode(newHLL(), regs, !dense))
+	return nil
+}
+
+// hllUnion returns the registers of the union of the HyperLogLogs at keys and
+// whether any of them is dense. Missing keys count as empty.
+func (s *Store) hllUnion(keys []string) ([]uint8, bool, error) {
+	union := make([]uint8, hllRegisters)
+	dense := false
+	for _, key := range keys {
+		b, err := s.hll(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if b == nil {
+			continue
+		}
+		dense = dense || b[4] == hllDense
+		regs, err := hllDecode(b)
+		if err != nil {
+			return nil, false, err
+		}
+		for i, v := range regs {
+			union[i] = max(union[i], v)
+		}
+	}
+	return union, dense, nil
+}
+
+// Stream returns the stream at key, nil if there is none.
+func (s *Store) Stream(key string) (*stream, error) {
+	val, ok, err := s.lookupKind(key, StreamType)
+	if !ok {
+		return nil, err
 	}
+	return val.value.(*stream), nil
 }
 
-func (s *Store) XAdd(stream, id string, fields map[string]string) string {
-	if id == "*" {
-		id = fmt.Sprintf("%d-0", time.Now().UnixNano()/1e6)
+// streamForWrite returns the stream at key, creating an empty one if there
+// is none, and counts as a modification of the key.
+func (s *Store) streamForWrite(key string) (*stream, error) {
+	st, err := s.Stream(key)
+	if err != nil {
+		return nil, err
 	}
-	entry := StreamEntry{ID: id, mu: &sync.RWMutex{}, Fields: fields}
-	s.streams[stream] = append(s.streams[stream], entry)
-	return id
+	if st == nil {
+		st = newStream()
+		s.data[key] = &StoreValue{kind: StreamType, value: st}
+	}
+	s.touch(key)
+	return st, nil
+}
+
+// XAdd adds an entry with an ID from NextID to the stream at key, creating
+// the stream if missing.
+func (s *Store) XAdd(key string, id streamID, fields []string) error {
+	st, err := s.streamForWrite(key)
+	if err != nil {
+		return err
+	}
+	st.Add(id, fields)
+	s.signalKeyReady(key)
+	return nil
+}
+
+// XDel deletes the entries with the given IDs from the stream at key and
+// returns how many there were.
+func (s *Store) XDel(key string, ids []streamID) (int, error) {
+	st, err := s.Stream(key)
+	if st == nil {
+		return 0, err
+	}
+	n := st.Delete(ids)
+	if n > 0 {
+		s.touch(key)
+	}
+	return n, nil
+}
+
+// XTrim trims the stream at key and returns the number of entries removed.
+func (s *Store) XTrim(key string, opts trimOptions) (int, error) {
+	st, err := s.Stream(key)
+	if st == nil {
+		return 0, err
+	}
+	n := st.Trim(opts)
+	if n > 0 {
+		s.touch(key)
+	}
+	return n, nil
+}
+
+// XGroupCreate adds the consumer group called group, which must not exist
+// yet, to the stream at key, creating the stream if missing. The group
+// starts reading after id.
+func (s *Store) XGroupCreate(key, group string, id streamID) error {
+	st, err := s.streamForWrite(key)
+	if err != nil {
+		return err
+	}
+	st.groups[group] = newConsumerGroup(id)
+	return nil
 }
 
-func (s *Store) XRange(stream, start, stop string) []StreamEntry {
-	steams := s.streams[stream]
-	var ans []StreamEntry
-	for _, entry := range steams {
-		if idsInRange(entry.ID, start, stop) {
-			ans = append(ans, entry)
+// XGroupDestroy removes a consumer group and reports whether it existed.
+func (s *Store) XGroupDestroy(key, group string) (bool, error) {
+	st, err := s.Stream(key)
+	if err != nil || st.Group(group) == nil {
+		return false, err
+	}
+	delete(st.groups, group)
+	s.touch(key)
+	// Clients blocked reading for the group have to find out it is gone.
+	s.signalKeyReady(key)
+	return true, nil
+}
+
+// XGroupSetID makes an existing consumer group read on after id.
+func (s *Store) XGroupSetID(key, group string, id streamID) {
+	st, _ := s.Stream(key)
+	st.Group(group).lastID = id
+	s.touch(key)
+}
+
+// XGroupCreateConsumer adds a consumer to an existing group and reports
+// whether it is new.
+func (s *Store) XGroupCreateConsumer(key, group, consumer string) bool {
+	st, _ := s.Stream(key)
+	_, created := st.Group(group).Consumer(consumer)
+	if created {
+		s.touch(key)
+	}
+	return created
+}
+
+// XGroupDelConsumer removes a consumer from an existing group and returns
+// the number of entries that were pending for it.
+func (s *Store) XGroupDelConsumer(key, group, consumer string) int {
+	st, _ := s.Stream(key)
+	g := st.Group(group)
+	if _, ok := g.consumers[consumer]; !ok {
+		return 0
+	}
+	s.touch(key)
+	return g.DeleteConsumer(consumer)
+}
+
+// XReadGroup reads the stream at key for consumer of an existing group,
+// creating the consumer if missing. With next set it returns the entries
+// never delivered to the group, which become pending for consumer unless
+// noack is set. Otherwise it returns the entries pending for consumer
+// with an ID greater than id, counting one more delivery of each; those
+// deleted from the stream meanwhile have no fields. count limits the
+// number of entries unless it is 0.
+func (s *Store) XReadGroup(key, group, consumer string, next bool, id streamID, count int, noack bool) []StreamEntry {
+	st, _ := s.Stream(key)
+	g := st.Group(group)
+	cons, created := g.Consumer(consumer)
+	var entries []StreamEntry
+	now := time.Now()
+	if next {
+		entries = st.After(g.lastID, count)
+		if len(entries) > 0 {
+			g.lastID = entries[len(entries)-1].ID
+		}
+		if !noack {
+			for _, entry := range entries {
+				g.Deliver(entry.ID, cons, now)
+			}
+		}
+	} else if start, ok := id.next(); ok {
+		for _, p := range cons.pending.Range(start, maxStreamID, count) {
+			entry, ok := st.Entry(p.id)
+			if !ok {
+				entries = append(entries, StreamEntry{ID: p.id})
+				continue
+			}
+			p.deliveryTime = now
+			p.deliveryCount++
+			entries = append(entries, entry)
 		}
 	}
-	return ans
+	if created || len(entries) > 0 {
+		s.touch(key)
+	}
+	return entries
 }
 
-func (s *Store) XRead(stream, id string) []StreamEntry {
-	var ans []StreamEntry
-	for _, entry := range s.streams[stream] {
-		if idGreaterThan(entry.ID, id) {
-			ans = append(ans, entry)
+// XClaim claims the entries with the given IDs pending in a consumer group
+// for consumer, creating the consumer if missing, and returns those
+// claimed. Entries deleted from the stream are no longer pending. lastID, if
+// greater, becomes the last ID delivered to the group.
+func (s *Store) XClaim(key, group, consumer string, ids []streamID, opts claimOptions, lastID streamID) []StreamEntry {
+	st, _ := s.Stream(key)
+	g := st.Group(group)
+	cons, _ := g.Consumer(consumer)
+	if lastID.compare(g.lastID) > 0 {
+		g.lastID = lastID
+	}
+	s.touch(key)
+	now := time.Now()
+	var claimed []StreamEntry
+	for _, id := range ids {
+		entry, ok := st.Entry(id)
+		p := g.pending.Get(id)
+		switch {
+		case p == nil && ok && opts.force:
+			p = &pendingEntry{id: id}
+			g.pending.Add(p)
+		case p == nil:
+			continue
+		case !ok:
+			g.Drop(p)
+			continue
+		}
+		if g.Claim(p, cons, opts, now) {
+			claimed = append(claimed, entry)
 		}
 	}
-	return ans
+	return claimed
+}
+
+// XAutoClaim is XClaim for the entries pending in a consumer group from
+// start on. It claims at most count of them, looking at no more than ten
+// times as many, and returns the ID to start the next call from (0-0 once
+// all were looked at) and the IDs of entries found deleted from the stream.
+func (s *Store) XAutoClaim(key, group, consumer string, start streamID, count int, opts claimOptions) (next streamID, claimed []StreamEntry, deleted []streamID) {
+	st, _ := s.Stream(key)
+	g := st.Group(group)
+	cons, _ := g.Consumer(consumer)
+	s.touch(key)
+	now := time.Now()
+	attempts := count * 10
+	pending := g.pending.Range(start, maxStreamID, attempts+1)
+	for i, p := range pending {
+		if i == attempts || len(claimed) == count {
+			return p.id, claimed, deleted
+		}
+		entry, ok := st.Entry(p.id)
+		if !ok {
+			g.Drop(p)
+			deleted = append(deleted, p.id)
+			continue
+		}
+		if g.Claim(p, cons, opts, now) {
+			claimed = append(claimed, entry)
+		}
+	}
+	return streamID{}, claimed, deleted
+}
+
+// XAck acknowledges the entries with the given IDs for a consumer group and
+// returns how many of them were pending.
+func (s *Store) XAck(key, group string, ids []streamID) (int, error) {
+	st, err := s.Stream(key)
+	g := st.Group(group)
+	if err != nil || g == nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		if g.Ack(id) {
+			n++
+		}
+	}
+	if n > 0 {
+		s.touch(key)
+	}
+	return n, nil
 }
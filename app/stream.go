@@ -0,0 +1,534 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidStreamID   = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errStreamIDZero      = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	errStreamIDTooSmall  = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// streamID is the ID of a stream entry: a millisecond time and a sequence
+// number within that millisecond.
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) compare(other streamID) int {
+	if c := cmp.Compare(id.ms, other.ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.seq, other.seq)
+}
+
+// next returns the smallest ID greater than id; ok is false if there is
+// none.
+func (id streamID) next() (next streamID, ok bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the greatest ID less than id; ok is false if there is none.
+func (id streamID) prev() (prev streamID, ok bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parseStreamID parses an ID given as an argument. An ID of only a
+// millisecond time gets sequence number seq, - and + are the smallest and
+// the largest ID.
+func parseStreamID(arg string, seq uint64) (streamID, error) {
+	switch arg {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	msPart, seqPart, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return streamID{}, errInvalidStreamID
+		}
+	}
+	return streamID{ms, seq}, nil
+}
+
+// stream is the value of a stream: its entries in ID order, kept in blocks
+// of up to streamBlockEntries, and the consumer groups reading it. lastID is
+// the ID of the last entry ever added, even if it was deleted since,
+// entriesAdded the number of entries ever added and maxDeletedID the
+// greatest ID deleted by XDEL.
+type stream struct {
+	blocks       []*streamBlock
+	length       int
+	lastID       streamID
+	entriesAdded uint64
+	maxDeletedID streamID
+	groups       map[string]*consumerGroup
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*consumerGroup)}
+}
+
+// Len returns the number of entries. A nil stream is empty.
+func (s *stream) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.length
+}
+
+// LastID returns the ID of the last entry added, 0-0 for a nil stream.
+func (s *stream) LastID() streamID {
+	if s == nil {
+		return streamID{}
+	}
+	return s.lastID
+}
+
+// FirstID returns the ID of the first entry, 0-0 if there is none.
+func (s *stream) FirstID() streamID {
+	if s.Len() == 0 {
+		return streamID{}
+	}
+	return s.blocks[0].first()
+}
+
+// NextID returns the ID of an entry added with the ID given to XADD: * for
+// one made of the time now, ms-* for the next one in millisecond ms, or an
+// explicit ID. The ID must be greater than any added before.
+func (s *stream) NextID(arg string, now time.Time) (streamID, error) {
+	last := s.LastID()
+	if arg == "*" {
+		if ms := uint64(now.UnixMilli()); ms > last.ms {
+			return streamID{ms, 0}, nil
+		}
+		id, ok := last.next()
+		if !ok {
+			return id, errStreamIDExhausted
+		}
+		return id, nil
+	}
+	if msPart, seqPart, _ := strings.Cut(arg, "-"); seqPart == "*" {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		switch {
+		case err != nil:
+			return streamID{}, errInvalidStreamID
+		case ms > last.ms:
+			return streamID{ms, 0}, nil
+		case ms < last.ms || last.seq == math.MaxUint64:
+			return streamID{}, errStreamIDTooSmall
+		}
+		return streamID{ms, last.seq + 1}, nil
+	}
+	if arg == "-" || arg == "+" {
+		return streamID{}, errInvalidStreamID
+	}
+	id, err := parseStreamID(arg, 0)
+	switch {
+	case err != nil:
+		return id, err
+	case id == streamID{}:
+		return id, errStreamIDZero
+	case id.compare(last) <= 0:
+		return id, errStreamIDTooSmall
+	}
+	return id, nil
+}
+
+// Add appends an entry with an ID from NextID and the given field names
+// and values.
+func (s *stream) Add(id streamID, fields []string) {
+	if len(s.blocks) == 0 || !s.blocks[len(s.blocks)-1].fits(fields) {
+		s.blocks = append(s.blocks, newStreamBlock())
+	}
+	s.blocks[len(s.blocks)-1].Append(id, fields)
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// locate returns the position of the first entry with an ID not less than
+// id: the index of its block and its index there. Past the last entry the
+// block index is len(s.blocks).
+func (s *stream) locate(id streamID) (int, int) {
+	bi := sort.Search(len(s.blocks), func(i int) bool { return s.blocks[i].last().compare(id) >= 0 })
+	if bi == len(s.blocks) {
+		return bi, 0
+	}
+	return bi, s.blocks[bi].search(id)
+}
+
+// Entry returns the entry with the given ID.
+func (s *stream) Entry(id streamID) (StreamEntry, bool) {
+	bi, i := s.locate(id)
+	if bi == len(s.blocks) || s.blocks[bi].ids[i] != id {
+		return StreamEntry{}, false
+	}
+	return s.blocks[bi].Entry(i), true
+}
+
+// First and Last return the first and the last entry.
+func (s *stream) First() (StreamEntry, bool) {
+	if s.Len() == 0 {
+		return StreamEntry{}, false
+	}
+	return s.blocks[0].Entry(0), true
+}
+
+func (s *stream) Last() (StreamEntry, bool) {
+	if s.Len() == 0 {
+		return StreamEntry{}, false
+	}
+	b := s.blocks[len(s.blocks)-1]
+	return b.Entry(b.Len() - 1), true
+}
+
+// Range returns the entries with an ID from start to end, at most count of
+// them unless count is 0. With rev set it starts from end and goes back.
+func (s *stream) Range(start, end streamID, rev bool, count int) []StreamEntry {
+	if s == nil || start.compare(end) > 0 {
+		return nil
+	}
+	var entries []StreamEntry
+	if !rev {
+		bi, i := s.locate(start)
+		for ; bi < len(s.blocks); bi, i = bi+1, 0 {
+			b := s.blocks[bi]
+			for ; i < b.Len(); i++ {
+				if b.ids[i].compare(end) > 0 || (count > 0 && len(entries) == count) {
+					return entries
+				}
+				entries = append(entries, b.Entry(i))
+			}
+		}
+		return entries
+	}
+	// Walk back from the first entry past end.
+	bi, i := len(s.blocks), 0
+	if next, ok := end.next(); ok {
+		bi, i = s.locate(next)
+	}
+	for count == 0 || len(entries) < count {
+		if i == 0 {
+			if bi == 0 {
+				break
+			}
+			bi--
+			i = s.blocks[bi].Len()
+		}
+		i--
+		b := s.blocks[bi]
+		if b.ids[i].compare(start) < 0 {
+			break
+		}
+		entries = append(entries, b.Entry(i))
+	}
+	return entries
+}
+
+// After returns the entries with an ID greater than id, at most count of them
+// unless count is 0.
+func (s *stream) After(id streamID, count int) []StreamEntry {
+	next, ok := id.next()
+	if !ok {
+		return nil
+	}
+	return s.Range(next, maxStreamID, false, count)
+}
+
+// Delete deletes the entries with the given IDs and returns how many there
+// were.
+func (s *stream) Delete(ids []streamID) int {
+	n := 0
+	for _, id := range ids {
+		bi, i := s.locate(id)
+		if bi == len(s.blocks) || s.blocks[bi].ids[i] != id {
+			continue
+		}
+		s.blocks[bi].Remove(i, i+1)
+		if s.blocks[bi].Len() == 0 {
+			s.blocks = slices.Delete(s.blocks, bi, bi+1)
+		}
+		s.length--
+		if id.compare(s.maxDeletedID) > 0 {
+			s.maxDeletedID = id
+		}
+		n++
+	}
+	return n
+}
+
+// trimOptions tell XTRIM and XADD how to trim a stream: down to its last
+// maxLen entries, or with byID set of the entries with an ID less than
+// minID. With approx set only whole blocks are removed, as Redis removes
+// whole nodes, and at most limit entries unless limit is 0.
+type trimOptions struct {
+	byID   bool
+	maxLen int
+	minID  streamID
+	approx bool
+	limit  int
+}
+
+// Trim trims the stream and returns the number of entries removed.
+func (s *stream) Trim(opts trimOptions) int {
+	removed := 0
+	for len(s.blocks) > 0 {
+		b := s.blocks[0]
+		n := min(max(s.length-opts.maxLen, 0), b.Len())
+		if opts.byID {
+			n = b.search(opts.minID)
+		}
+		if n == 0 || (opts.approx && (n < b.Len() || (opts.limit > 0 && removed+n > opts.limit))) {
+			break
+		}
+		removed += n
+		s.length -= n
+		if n < b.Len() {
+			b.Remove(0, n)
+			break
+		}
+		s.blocks[0] = nil
+		s.blocks = s.blocks[1:]
+	}
+	return removed
+}
+
+// Group returns the consumer group called name, nil if there is none.
+func (s *stream) Group(name string) *consumerGroup {
+	if s == nil {
+		return nil
+	}
+	return s.groups[name]
+}
+
+func (s *stream) Clone() *stream {
+	c := *s
+	c.blocks = make([]*streamBlock, len(s.blocks))
+	for i, b := range s.blocks {
+		c.blocks[i] = b.Clone()
+	}
+	c.groups = make(map[string]*consumerGroup, len(s.groups))
+	for name, g := range s.groups {
+		c.groups[name] = g.Clone()
+	}
+	return &c
+}
+
+// consumerGroup is a consumer group of a stream. lastID is the ID of the
+// last entry delivered to it, pending holds the entries delivered but not
+// acknowledged yet.
+type consumerGroup struct {
+	lastID    streamID
+	pending   *pendingList
+	consumers map[string]*consumer
+}
+
+// consumer is a consumer of a group, with the pending entries delivered to
+// it.
+type consumer struct {
+	name    string
+	pending *pendingList
+}
+
+// pendingEntry is an entry delivered to a consumer and not acknowledged yet.
+type pendingEntry struct {
+	id            streamID
+	consumer      *consumer
+	deliveryTime  time.Time
+	deliveryCount int
+}
+
+func newConsumerGroup(lastID streamID) *consumerGroup {
+	return &consumerGroup{lastID: lastID, pending: newPendingList(), consumers: make(map[string]*consumer)}
+}
+
+// Consumer returns the consumer called name, creating it if missing. created
+// reports whether it was.
+func (g *consumerGroup) Consumer(name string) (cons *consumer, created bool) {
+	if cons, ok := g.consumers[name]; ok {
+		return cons, false
+	}
+	cons = &consumer{name: name, pending: newPendingList()}
+	g.consumers[name] = cons
+	return cons, true
+}
+
+// DeleteConsumer removes the consumer called name along with its pending
+// entries and returns how many it had.
+func (g *consumerGroup) DeleteConsumer(name string) int {
+	cons, ok := g.consumers[name]
+	if !ok {
+		return 0
+	}
+	for _, p := range cons.pending.Range(streamID{}, maxStreamID, 0) {
+		g.pending.Remove(p.id)
+	}
+	delete(g.consumers, name)
+	return cons.pending.Len()
+}
+
+// Deliver records that the entry with the given ID was delivered to cons at
+// now. A new delivery starts the count afresh, even if the entry was pending
+// for another consumer.
+func (g *consumerGroup) Deliver(id streamID, cons *consumer, now time.Time) {
+	p := g.pending.Get(id)
+	if p == nil {
+		p = &pendingEntry{id: id}
+		g.pending.Add(p)
+	}
+	g.assign(p, cons)
+	p.deliveryTime, p.deliveryCount = now, 1
+}
+
+// assign makes the pending entry p pending for cons.
+func (g *consumerGroup) assign(p *pendingEntry, cons *consumer) {
+	if p.consumer == cons {
+		return
+	}
+	if p.consumer != nil {
+		p.consumer.pending.Remove(p.id)
+	}
+	p.consumer = cons
+	cons.pending.Add(p)
+}
+
+// Drop removes p from the pending entries.
+func (g *consumerGroup) Drop(p *pendingEntry) {
+	g.pending.Remove(p.id)
+	p.consumer.pending.Remove(p.id)
+}
+
+// claimOptions tell how XCLAIM and XAUTOCLAIM claim pending entries: only
+// those idle for at least minIdle, recording a delivery at deliveryTime.
+// The delivery count becomes retryCount unless it is negative; otherwise it
+// grows by one unless justID is set. force makes entries of the stream that
+// are not pending yet pending for the claiming consumer.
+type claimOptions struct {
+	minIdle      time.Duration
+	deliveryTime time.Time
+	retryCount   int
+	force        bool
+	justID       bool
+}
+
+// Claim makes the pending entry p pending for cons if it has been idle long
+// enough at now, and reports whether it did.
+func (g *consumerGroup) Claim(p *pendingEntry, cons *consumer, opts claimOptions, now time.Time) bool {
+	if opts.minIdle > 0 && now.Sub(p.deliveryTime) < opts.minIdle {
+		return false
+	}
+	g.assign(p, cons)
+	p.deliveryTime = opts.deliveryTime
+	switch {
+	case opts.retryCount >= 0:
+		p.deliveryCount = opts.retryCount
+	case !opts.justID:
+		p.deliveryCount++
+	}
+	return true
+}
+
+// Ack removes the entry with the given ID from the pending entries and
+// reports whether it was pending.
+func (g *consumerGroup) Ack(id streamID) bool {
+	p := g.pending.Get(id)
+	if p == nil {
+		return false
+	}
+	g.Drop(p)
+	return true
+}
+
+func (g *consumerGroup) Clone() *consumerGroup {
+	c := newConsumerGroup(g.lastID)
+	for name := range g.consumers {
+		c.Consumer(name)
+	}
+	for _, p := range g.pending.Range(streamID{}, maxStreamID, 0) {
+		cp := *p
+		cp.consumer = c.consumers[p.consumer.name]
+		c.pending.Add(&cp)
+		cp.consumer.pending.Add(&cp)
+	}
+	return c
+}
+
+// pendingList is a list of pending entries ordered by ID.
+type pendingList struct {
+	ids     []streamID
+	entries map[streamID]*pendingEntry
+}
+
+func newPendingList() *pendingList {
+	return &pendingList{entries: make(map[streamID]*pendingEntry)}
+}
+
+func (l *pendingList) Len() int {
+	return len(l.ids)
+}
+
+func (l *pendingList) Get(id streamID) *pendingEntry {
+	return l.entries[id]
+}
+
+func (l *pendingList) Add(p *pendingEntry) {
+	i, found := slices.BinarySearchFunc(l.ids, p.id, streamID.compare)
+	if !found {
+		l.ids = slices.Insert(l.ids, i, p.id)
+	}
+	l.entries[p.id] = p
+}
+
+func (l *pendingList) Remove(id streamID) {
+	if i, found := slices.BinarySearchFunc(l.ids, id, streamID.compare); found {
+		l.ids = slices.Delete(l.ids, i, i+1)
+		delete(l.entries, id)
+	}
+}
+
+// Range returns the pending entries with an ID from start to end, at most
+// count of them unless count is 0.
+func (l *pendingList) Range(start, end streamID, count int) []*pendingEntry {
+	i, _ := slices.BinarySearchFunc(l.ids, start, streamID.compare)
+	var entries []*pendingEntry
+	for ; i < len(l.ids) && l.ids[i].compare(end) <= 0; i++ {
+		if count > 0 && len(entries) == count {
+			break
+		}
+		entries = append(entries, l.entries[l.ids[i]])
+	}
+	return entries
+}
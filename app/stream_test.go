@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testStreamID returns the ID of the i-th entry of the streams built by
+// streamOf: three entries a millisecond, every other millisecond, so that
+// there are IDs between the entries.
+func testStreamID(i int) streamID {
+	return streamID{uint64(2*(i/3) + 2), uint64(i % 3)}
+}
+
+func streamOf(n int) (*stream, []streamID) {
+	s := newStream()
+	fillStream(s, n)
+	ids := make([]streamID, n)
+	for i := range ids {
+		ids[i] = testStreamID(i)
+	}
+	return s, ids
+}
+
+// checkInvariants checks that the blocks are neither empty nor over
+// streamBlockEntries or, past one entry, streamBlockMaxBytes, that their
+// offsets cover their fields and the fields their bytes, and that they
+// hold length entries in ascending order, none after the last ID.
+func (s *stream) checkInvariants() error {
+	var prev streamID
+	count := 0
+	for _, b := range s.blocks {
+		if b.Len() == 0 || b.Len() > streamBlockEntries {
+			return fmt.Errorf("block of %d entries", b.Len())
+		}
+		if len(b.offsets) != b.Len()+1 || b.offsets[0] != 0 || !slices.IsSorted(b.offsets) || int(b.offsets[b.Len()]) != len(b.ends) {
+			return fmt.Errorf("block of %d entries and %d fields has offsets %v", b.Len(), len(b.ends), b.offsets)
+		}
+		if !slices.IsSorted(b.ends) || len(b.ends) > 0 && int(b.ends[len(b.ends)-1]) != len(b.data) {
+			return fmt.Errorf("block of %d bytes has field ends %v", len(b.data), b.ends)
+		}
+		if b.Len() > 1 && len(b.data) > streamBlockMaxBytes {
+			return fmt.Errorf("block of %d entries has %d bytes", b.Len(), len(b.data))
+		}
+		for _, id := range b.ids {
+			if count > 0 && id.compare(prev) <= 0 {
+				return fmt.Errorf("entry %v follows %v", id, prev)
+			}
+			prev = id
+			count++
+		}
+	}
+	if count != s.length || (count > 0 && prev.compare(s.lastID) > 0) {
+		return fmt.Errorf("blocks hold %d entries up to %v, length is %d and last ID %v", count, prev, s.length, s.lastID)
+	}
+	return nil
+}
+
+func (s *stream) contents() []streamID {
+	var ids []streamID
+	for _, b := range s.blocks {
+		ids = append(ids, b.ids...)
+	}
+	return ids
+}
+
+func entryIDs(entries []StreamEntry) []streamID {
+	ids := make([]streamID, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func TestStreamLocate(t *testing.T) {
+	s, ids := streamOf(3*streamBlockEntries + 10)
+	for i, id := range ids {
+		bi, j := s.locate(id)
+		if bi != i/streamBlockEntries || j != i%streamBlockEntries {
+			t.Fatalf("locate(%v) = %d, %d", id, bi, j)
+		}
+		// An ID between two entries locates the later one.
+		if id.seq == 0 {
+			bi, j = s.locate(streamID{id.ms - 1, 7})
+			if s.blocks[bi].ids[j] != id {
+				t.Fatalf("locate before %v found %v", id, s.blocks[bi].ids[j])
+			}
+		}
+	}
+	if bi, j := s.locate(streamID{}); bi != 0 || j != 0 {
+		t.Fatalf("locate(0-0) = %d, %d", bi, j)
+	}
+	if bi, _ := s.locate(maxStreamID); bi != len(s.blocks) {
+		t.Fatalf("locate past the end = %d", bi)
+	}
+	if e, ok := s.Entry(ids[streamBlockEntries]); !ok || e.Fields[1] != ids[streamBlockEntries].String() {
+		t.Fatalf("Entry(%v) = %v, %v", ids[streamBlockEntries], e, ok)
+	}
+}
+
+func TestStreamRange(t *testing.T) {
+	s, ids := streamOf(5*streamBlockEntries + 37)
+	// Empty a whole block and punch holes around block boundaries.
+	var deleted []streamID
+	deleted = append(deleted, ids[2*streamBlockEntries:3*streamBlockEntries]...)
+	deleted = append(deleted, ids[streamBlockEntries-1], ids[streamBlockEntries], ids[4*streamBlockEntries+1])
+	s.Delete(deleted)
+	ids = slices.DeleteFunc(ids, func(id streamID) bool { return slices.Contains(deleted, id) })
+	checkContents(t, s, ids)
+
+	// model returns the IDs from start to end, at most count of them.
+	model := func(start, end streamID, rev bool, count int) []streamID {
+		var want []streamID
+		for _, id := range ids {
+			if id.compare(start) >= 0 && id.compare(end) <= 0 {
+				want = append(want, id)
+			}
+		}
+		if rev {
+			slices.Reverse(want)
+		}
+		if count > 0 && len(want) > count {
+			want = want[:count]
+		}
+		return want
+	}
+	rnd := rand.New(rand.NewSource(1))
+	bound := func() streamID {
+		switch rnd.Intn(10) {
+		case 0:
+			return streamID{}
+		case 1:
+			return maxStreamID
+		}
+		id := testStreamID(rnd.Intn(len(ids) + len(deleted)))
+		if rnd.Intn(3) == 0 {
+			id.seq += 5
+		}
+		return id
+	}
+	for range 5000 {
+		start, end := bound(), bound()
+		count := rnd.Intn(3) * rnd.Intn(2*streamBlockEntries)
+		for _, rev := range []bool{false, true} {
+			entries := s.Range(start, end, rev, count)
+			if got, want := entryIDs(entries), model(start, end, rev, count); !slices.Equal(got, want) {
+				t.Fatalf("Range(%v, %v, %v, %d) = %v, want %v", start, end, rev, count, got, want)
+			}
+			for _, e := range entries {
+				if !slices.Equal(e.Fields, []string{"id", e.ID.String()}) {
+					t.Fatalf("entry %v has fields %v", e.ID, e.Fields)
+				}
+			}
+		}
+	}
+	if got := entryIDs(s.After(ids[streamBlockEntries-2], 3)); !slices.Equal(got, ids[streamBlockEntries-1:streamBlockEntries+2]) {
+		t.Fatalf("After across a block boundary = %v", got)
+	}
+}
+
+func TestStreamDelete(t *testing.T) {
+	s, ids := streamOf(3 * streamBlockEntries)
+	if n := s.Delete(ids[streamBlockEntries : 2*streamBlockEntries]); n != streamBlockEntries {
+		t.Fatalf("deleted %d entries", n)
+	}
+	if len(s.blocks) != 2 {
+		t.Fatalf("emptied block kept: %d blocks", len(s.blocks))
+	}
+	if s.maxDeletedID != ids[2*streamBlockEntries-1] {
+		t.Fatalf("max deleted ID %v", s.maxDeletedID)
+	}
+	if n := s.Delete([]streamID{ids[streamBlockEntries], {1, 0}, maxStreamID}); n != 0 {
+		t.Fatalf("deleted %d missing entries", n)
+	}
+	ids = slices.Delete(ids, streamBlockEntries, 2*streamBlockEntries)
+	checkContents(t, s, ids)
+	s.Delete(ids)
+	checkContents(t, s, nil)
+	if len(s.blocks) != 0 || s.LastID() != testStreamID(3*streamBlockEntries-1) {
+		t.Fatalf("empty stream has %d blocks, last ID %v", len(s.blocks), s.LastID())
+	}
+}
+
+func TestStreamBlockBytes(t *testing.T) {
+	s := newStream()
+	var ids []streamID
+	for i, size := range []int{1000, 1000, 1000, 1000, 10000, 10, 5000} {
+		id := testStreamID(i)
+		s.Add(id, []string{"v", strings.Repeat("x", size), "id", id.String()})
+		ids = append(ids, id)
+	}
+	checkContents(t, s, ids)
+	var lens []int
+	for _, b := range s.blocks {
+		lens = append(lens, b.Len())
+	}
+	if !slices.Equal(lens, []int{4, 1, 1, 1}) {
+		t.Fatalf("entries split into blocks of %v", lens)
+	}
+	s.Delete(ids[1:2])
+	for _, e := range s.Range(streamID{}, maxStreamID, false, 0) {
+		if len(e.Fields) != 4 || e.Fields[3] != e.ID.String() {
+			t.Fatalf("entry %v has fields %q", e.ID, e.Fields)
+		}
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	const n = 4*streamBlockEntries + 50
+	tests := []struct {
+		name    string
+		opts    trimOptions
+		removed int
+	}{
+		{"maxlen within a block", trimOptions{maxLen: n - 30}, 30},
+		{"maxlen across blocks", trimOptions{maxLen: 120}, n - 120},
+		{"maxlen 0", trimOptions{maxLen: 0}, n},
+		{"approx maxlen keeps a partial block", trimOptions{maxLen: n - 150, approx: true}, streamBlockEntries},
+		{"approx maxlen limit", trimOptions{maxLen: 0, approx: true, limit: 2*streamBlockEntries + 10}, 2 * streamBlockEntries},
+		{"approx maxlen limit below a block", trimOptions{maxLen: 0, approx: true, limit: streamBlockEntries - 1}, 0},
+		{"minid", trimOptions{byID: true, minID: testStreamID(250)}, 250},
+		{"minid between entries", trimOptions{byID: true, minID: streamID{testStreamID(249).ms - 1, 9}}, 249},
+		{"approx minid", trimOptions{byID: true, minID: testStreamID(250), approx: true}, 2 * streamBlockEntries},
+		{"minid past the end", trimOptions{byID: true, minID: maxStreamID}, n},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ids := streamOf(n)
+			if removed := s.Trim(tt.opts); removed != tt.removed {
+				t.Fatalf("removed %d entries, want %d", removed, tt.removed)
+			}
+			checkContents(t, s, ids[tt.removed:])
+			if tt.removed == n && len(s.blocks) != 0 {
+				t.Fatalf("trimmed stream kept %d blocks", len(s.blocks))
+			}
+		})
+	}
+}
+
+// benchStream is what the stream benchmarks need of an encoding, so that
+// blocks can be compared with the slice of entries streams were kept in
+// before.
+type benchStream interface {
+	Add(id streamID, fields []string)
+	Range(start, end streamID, rev bool, count int) []StreamEntry
+}
+
+// sliceStream is a stream kept as a slice of entries with string IDs and
+// fields in a map, searched by scanning from the start and parsing each ID.
+type sliceStream struct {
+	entries []sliceStreamEntry
+}
+
+type sliceStreamEntry struct {
+	id     string
+	fields map[string]string
+}
+
+func (s *sliceStream) Add(id streamID, fields []string) {
+	m := make(map[string]string, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		m[fields[i]] = fields[i+1]
+	}
+	s.entries = append(s.entries, sliceStreamEntry{id: id.String(), fields: m})
+}
+
+func (s *sliceStream) Range(start, end streamID, rev bool, count int) []StreamEntry {
+	var entries []StreamEntry
+	for _, e := range s.entries {
+		id, _ := parseStreamID(e.id, 0)
+		if id.compare(start) < 0 || id.compare(end) > 0 {
+			continue
+		}
+		fields := make([]string, 0, 2*len(e.fields))
+		for f, v := range e.fields {
+			fields = append(fields, f, v)
+		}
+		entries = append(entries, StreamEntry{ID: id, Fields: fields})
+		if !rev && len(entries) == count {
+			break
+		}
+	}
+	if rev {
+		slices.Reverse(entries)
+		if count > 0 && len(entries) > count {
+			entries = entries[:count]
+		}
+	}
+	return entries
+}
+
+const benchStreamLen = 1_000_000
+
+var streamEncodings = []struct {
+	name string
+	new  func() benchStream
+}{
+	{"blocks", func() benchStream { return newStream() }},
+	{"slice", func() benchStream { return &sliceStream{} }},
+}
+
+// fillStream adds n entries with IDs from testStreamID, each with a field
+// holding its ID.
+func fillStream(s benchStream, n int) {
+	for i := range n {
+		id := testStreamID(i)
+		s.Add(id, []string{"id", id.String()})
+	}
+}
+
+// benchStreams holds a stream of benchStreamLen entries in each encoding,
+// built once for the benchmarks that only read them.
+var benchStreams = sync.OnceValue(func() map[string]benchStream {
+	streams := make(map[string]benchStream)
+	for _, enc := range streamEncodings {
+		streams[enc.name] = enc.new()
+		fillStream(streams[enc.name], benchStreamLen)
+	}
+	return streams
+})
+
+// benchStreamEncodings runs f on the stream of benchStreamLen entries in
+// each encoding.
+func benchStreamEncodings(b *testing.B, f func(b *testing.B, s benchStream)) {
+	for _, enc := range streamEncodings {
+		b.Run(enc.name, func(b *testing.B) {
+			s := benchStreams()[enc.name]
+			b.ResetTimer()
+			f(b, s)
+		})
+	}
+}
+
+// BenchmarkStreamAdd measures XADD to a stream of a million entries.
+func BenchmarkStreamAdd(b *testing.B) {
+	for _, enc := range streamEncodings {
+		b.Run(enc.name, func(b *testing.B) {
+			s := enc.new()
+			fillStream(s, benchStreamLen)
+			fields := []string{"field", "value"}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				s.Add(testStreamID(benchStreamLen+i), fields)
+			}
+		})
+	}
+}
+
+// BenchmarkStreamMemory reports the heap taken by a stream of a million
+// entries, per entry.
+func BenchmarkStreamMemory(b *testing.B) {
+	for _, enc := range streamEncodings {
+		b.Run(enc.name, func(b *testing.B) {
+			var heap uint64
+			for range b.N {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				s := enc.new()
+				fillStream(s, benchStreamLen)
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(s)
+				heap += after.HeapAlloc - before.HeapAlloc
+			}
+			b.ReportMetric(float64(heap)/float64(b.N)/benchStreamLen, "B/entry")
+		})
+	}
+}
+
+// BenchmarkStreamRangeSeek measures XRANGE of ten entries from a random
+// start in a stream of a million entries.
+func BenchmarkStreamRangeSeek(b *testing.B) {
+	benchStreamEncodings(b, func(b *testing.B, s benchStream) {
+		rnd := rand.New(rand.NewSource(1))
+		for range b.N {
+			s.Range(testStreamID(rnd.Intn(benchStreamLen)), maxStreamID, false, 10)
+		}
+	})
+}
+
+// BenchmarkStreamRevRangeSeek is BenchmarkStreamRangeSeek for XREVRANGE.
+func BenchmarkStreamRevRangeSeek(b *testing.B) {
+	benchStreamEncodings(b, func(b *testing.B, s benchStream) {
+		rnd := rand.New(rand.NewSource(1))
+		for range b.N {
+			s.Range(streamID{}, testStreamID(rnd.Intn(benchStreamLen)), true, 10)
+		}
+	})
+}
+
+// BenchmarkStreamRead measures XREAD of ten entries after a random ID in a
+// stream of a million entries, through the store as the command does it.
+func BenchmarkStreamRead(b *testing.B) {
+	GlobalStore.mu.Lock()
+	defer GlobalStore.mu.Unlock()
+	GlobalStore.data["bench:stream"] = &StoreValue{kind: StreamType, value: benchStreams()["blocks"]}
+	defer delete(GlobalStore.data, "bench:stream")
+	streams, ids := []string{"bench:stream"}, make([]streamID, 1)
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for range b.N {
+		ids[0] = testStreamID(rnd.Intn(benchStreamLen))
+		readStreams(streams, ids, 10)
+	}
+}
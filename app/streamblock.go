@@ -0,0 +1,120 @@
+package main
+
+import (
+	"slices"
+	"sort"
+)
+
+// streamBlockEntries is the number of entries a stream block holds, the
+// default stream-node-max-entries of Redis.
+const streamBlockEntries = 100
+
+// streamBlockMaxBytes is the size of field names and values past which a
+// block takes no more entries, the default stream-node-max-bytes of Redis.
+// A larger entry gets a block of its own.
+const streamBlockMaxBytes = 4096
+
+// streamBlock holds consecutive entries of a stream, like a listpack of a
+// Redis stream. The field names and values of all its entries are packed
+// into one byte slice, so an entry costs its bytes and an end offset per
+// field rather than a string header and an allocation per field: field k
+// ends at ends[k] and starts where field k-1 ends, and entry i owns fields
+// offsets[i] to offsets[i+1]-1.
+type streamBlock struct {
+	ids     []streamID
+	offsets []int32
+	ends    []int32
+	data    []byte
+}
+
+func newStreamBlock() *streamBlock {
+	return &streamBlock{
+		ids:     make([]streamID, 0, streamBlockEntries),
+		offsets: append(make([]int32, 0, streamBlockEntries+1), 0),
+	}
+}
+
+func (b *streamBlock) Len() int {
+	return len(b.ids)
+}
+
+func (b *streamBlock) first() streamID {
+	return b.ids[0]
+}
+
+func (b *streamBlock) last() streamID {
+	return b.ids[len(b.ids)-1]
+}
+
+// fits reports whether an entry with fields may be appended to the block.
+func (b *streamBlock) fits(fields []string) bool {
+	if b.Len() == 0 {
+		return true
+	}
+	size := len(b.data)
+	for _, f := range fields {
+		size += len(f)
+	}
+	return b.Len() < streamBlockEntries && size <= streamBlockMaxBytes
+}
+
+// search returns the index of the first entry with an ID not less than id.
+func (b *streamBlock) search(id streamID) int {
+	return sort.Search(len(b.ids), func(i int) bool { return b.ids[i].compare(id) >= 0 })
+}
+
+// fieldStart returns the offset in data of field k.
+func (b *streamBlock) fieldStart(k int32) int32 {
+	if k == 0 {
+		return 0
+	}
+	return b.ends[k-1]
+}
+
+// Entry returns entry i, with its fields copied out of the block.
+func (b *streamBlock) Entry(i int) StreamEntry {
+	first, last := b.offsets[i], b.offsets[i+1]
+	start := b.fieldStart(first)
+	data := string(b.data[start:b.fieldStart(last)])
+	fields := make([]string, last-first)
+	for k := range fields {
+		end := b.ends[first+int32(k)]
+		fields[k] = data[:end-start]
+		data, start = data[end-start:], end
+	}
+	return StreamEntry{ID: b.ids[i], Fields: fields}
+}
+
+func (b *streamBlock) Append(id streamID, fields []string) {
+	b.ids = append(b.ids, id)
+	for _, f := range fields {
+		b.data = append(b.data, f...)
+		b.ends = append(b.ends, int32(len(b.data)))
+	}
+	b.offsets = append(b.offsets, int32(len(b.ends)))
+}
+
+// Remove removes entries i to j-1.
+func (b *streamBlock) Remove(i, j int) {
+	first, last := b.offsets[i], b.offsets[j]
+	start, end := b.fieldStart(first), b.fieldStart(last)
+	b.ids = slices.Delete(b.ids, i, j)
+	b.data = slices.Delete(b.data, int(start), int(end))
+	b.ends = slices.Delete(b.ends, int(first), int(last))
+	for k := int(first); k < len(b.ends); k++ {
+		b.ends[k] -= end - start
+	}
+	b.offsets = slices.Delete(b.offsets, i+1, j+1)
+	for k := i + 1; k < len(b.offsets); k++ {
+		b.offsets[k] -= last - first
+	}
+}
+
+func (b *streamBlock) Clone() *streamBlock {
+	return &streamBlock{
+		ids:     slices.Clone(b.ids),
+		offsets: slices.Clone(b.offsets),
+		ends:    slices.Clone(b.ends),
+		data:    slices.Clone(b.data),
+	}
+}
@@ -0,0 +1,858 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XRangeSerialized is an entry in a reply. deleted marks an entry still
+// pending for a consumer but deleted from the stream, which has no fields.
+type XRangeSerialized struct {
+	id      string
+	fields  []string
+	deleted bool
+}
+
+type XReadSerialized struct {
+	stream  []string
+	entries [][]XRangeSerialized
+}
+
+// serializeEntries prepares entries for a reply. Entries without fields are
+// pending entries deleted from the stream.
+func serializeEntries(entries []StreamEntry) []XRangeSerialized {
+	data := make([]XRangeSerialized, 0, len(entries))
+	for _, entry := range entries {
+		data = append(data, XRangeSerialized{id: entry.ID.String(), fields: entry.Fields, deleted: entry.Fields == nil})
+	}
+	return data
+}
+
+func handleXread(c *Client, args []string) error {
+	block, count := -1, 0
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "STREAMS" {
+			break
+		}
+		if i+1 == len(args) || (opt != "BLOCK" && opt != "COUNT") {
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+		}
+		if opt == "BLOCK" {
+			if n < 0 {
+				return respWriter(c, ERROR, "ERR timeout is negative")
+			}
+			block = n
+		} else {
+			count = max(n, 0)
+		}
+		i++
+	}
+	params := args[min(i+1, len(args)):]
+	if len(params) == 0 || len(params)%2 != 0 {
+		return respWriter(c, ERROR, "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	if !c.canBlock() {
+		block = -1
+	}
+	return serveXread(c, block, count, params)
+}
+
+// serveXread replies with the entries following ids in streams. With block
+// >= 0 and nothing to read yet it waits until one of the streams gets a new
+// entry, or until block milliseconds have passed (forever for 0).
+func serveXread(c *Client, block int, count int, params []string) error {
+	half := len(params) / 2
+	streams, ids := params[:half], make([]streamID, half)
+	for i, stream := range streams {
+		st, err := GlobalStore.Stream(stream)
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		// $ means only entries added after XREAD was called.
+		if params[half+i] == "$" {
+			ids[i] = st.LastID()
+		} else if ids[i], err = parseStreamID(params[half+i], 0); err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+	}
+	if ans, ok := readStreams(streams, ids, count); ok {
+		return respAny(c, ans)
+	}
+	var ans XReadSerialized
+	if block < 0 || !c.blockOn(streams, time.Duration(block)*time.Millisecond, func(string) bool {
+		var ok bool
+		ans, ok = readStreams(streams, ids, count)
+		return ok
+	}) {
+		_, err := c.Write([]byte("*-1\r\n"))
+		return err
+	}
+	return respAny(c, ans)
+}
+
+// readStreams collects the entries following ids in streams. Streams with
+// nothing new are left out of the reply; ok is false if all of them are.
+func readStreams(streams []string, ids []streamID, count int) (XReadSerialized, bool) {
+	var ans XReadSerialized
+	for i, stream := range streams {
+		st, _ := GlobalStore.Stream(stream)
+		entries := st.After(ids[i], count)
+		if len(entries) == 0 {
+			continue
+		}
+		ans.stream = append(ans.stream, stream)
+		ans.entries = append(ans.entries, serializeEntries(entries))
+	}
+	return ans, len(ans.stream) > 0
+}
+
+// handleXrange implements XRANGE key start end [COUNT count] and XREVRANGE
+// key end start [COUNT count]. - and + are the smallest and the largest ID,
+// an ID of only a millisecond time stands for the first entry of that
+// millisecond as start and for the last as end, and ( makes an end
+// exclusive.
+func handleXrange(c *Client, args []string) error {
+	rev := strings.EqualFold(args[0], "xrevrange")
+	startArg, endArg := args[2], args[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseIntervalID(startArg, 0, true)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	end, err := parseIntervalID(endArg, math.MaxUint64, false)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	count := 0
+	if len(args) > 4 {
+		if len(args) != 6 || !strings.EqualFold(args[4], "COUNT") {
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+		if count, err = strconv.Atoi(args[5]); err != nil {
+			return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+		}
+	}
+	st, err := GlobalStore.Stream(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if len(args) > 4 && count <= 0 {
+		return respArrayLen(c, 0)
+	}
+	return respAny(c, serializeEntries(st.Range(start, end, rev, count)))
+}
+
+// trimArgs are the trimming options of XTRIM and XADD. trim is set if
+// there are any. For XADD, id is the index of the ID argument.
+type trimArgs struct {
+	trimOptions
+	trim       bool
+	nomkstream bool
+	id         int
+}
+
+// parseTrimArgs parses the trimming options of XTRIM, and of XADD along
+// with NOMKSTREAM, which end at the first argument that is not an option.
+// An approximate trim removes at most 10000 entries unless LIMIT says
+// otherwise. msg is the error reply if the options are invalid.
+func parseTrimArgs(args []string, xadd bool) (a trimArgs, msg string) {
+	limit := -1
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "NOMKSTREAM" && xadd:
+			a.nomkstream = true
+		case (opt == "MAXLEN" || opt == "MINID") && i+1 < len(args):
+			if a.trim && a.byID != (opt == "MINID") {
+				return a, "ERR syntax error, MAXLEN and MINID options at the same time are not compatible"
+			}
+			a.trim, a.byID, a.approx = true, opt == "MINID", false
+			if (args[i+1] == "~" || args[i+1] == "=") && i+2 < len(args) {
+				a.approx = args[i+1] == "~"
+				i++
+			}
+			i++
+			if a.byID {
+				id, err := parseStreamID(args[i], 0)
+				if err != nil {
+					return a, err.Error()
+				}
+				a.minID = id
+				continue
+			}
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return a, "ERR value is not an integer or out of range"
+			}
+			if n < 0 {
+				return a, "ERR The MAXLEN argument must be >= 0."
+			}
+			a.maxLen = n
+		case opt == "LIMIT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return a, "ERR value is not an integer or out of range"
+			}
+			if n < 0 {
+				return a, "ERR The LIMIT argument must be >= 0."
+			}
+			limit = n
+			i++
+		case xadd:
+			break options
+		default:
+			return a, "ERR syntax error"
+		}
+	}
+	switch {
+	case xadd && i == len(args):
+		return a, arityError("xadd")
+	case !xadd && !a.trim:
+		return a, "ERR syntax error"
+	case limit >= 0 && !a.approx:
+		return a, "ERR syntax error, LIMIT cannot be used without the special ~ option"
+	case limit >= 0:
+		a.limit = limit
+	case a.approx:
+		a.limit = 10000
+	}
+	a.id = i
+	return a, ""
+}
+
+// handleXadd implements XADD key [NOMKSTREAM] [MAXLEN | MINID [= | ~]
+// threshold [LIMIT count]] * | id field value [field value ...].
+func handleXadd(c *Client, args []string) error {
+	key := args[1]
+	a, msg := parseTrimArgs(args, true)
+	if msg != "" {
+		return respWriter(c, ERROR, msg)
+	}
+	i := a.id
+	if pairs := args[i+1:]; len(pairs) == 0 || len(pairs)%2 != 0 {
+		return respWriter(c, ERROR, arityError(args[0]))
+	}
+	st, err := GlobalStore.Stream(key)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if st == nil && a.nomkstream {
+		_, err := c.Write([]byte("$-1\r\n"))
+		return err
+	}
+	id, err := st.NextID(args[i], time.Now())
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if err := GlobalStore.XAdd(key, id, args[i+1:]); err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if a.trim {
+		GlobalStore.XTrim(key, a.trimOptions)
+	}
+	return respWriter(c, BULK, id.String())
+}
+
+// handleXtrim implements XTRIM key MAXLEN | MINID [= | ~] threshold
+// [LIMIT count].
+func handleXtrim(c *Client, args []string) error {
+	a, msg := parseTrimArgs(args, false)
+	if msg != "" {
+		return respWriter(c, ERROR, msg)
+	}
+	n, err := GlobalStore.XTrim(args[1], a.trimOptions)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+func handleXdel(c *Client, args []string) error {
+	ids := make([]streamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		ids[i] = id
+	}
+	n, err := GlobalStore.XDel(args[1], ids)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+func handleXlen(c *Client, args []string) error {
+	st, err := GlobalStore.Stream(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(st.Len()))
+}
+
+// handleXinfo implements XINFO STREAM key, which replies with the length
+// and the metadata of a stream along with its first and last entry.
+func handleXinfo(c *Client, args []string) error {
+	if !strings.EqualFold(args[1], "STREAM") {
+		return respWriter(c, ERROR, fmt.Sprintf("ERR unknown subcommand '%s'. Try XINFO HELP.", args[1]))
+	}
+	if len(args) != 3 {
+		return respWriter(c, ERROR, arityError("xinfo|stream"))
+	}
+	st, err := GlobalStore.Stream(args[2])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if st == nil {
+		return respWriter(c, ERROR, "ERR no such key")
+	}
+	if err := respArrayLen(c, 16); err != nil {
+		return err
+	}
+	info := []struct {
+		name, value string
+		integer     bool
+	}{
+		{"length", strconv.Itoa(st.Len()), true},
+		{"last-generated-id", st.lastID.String(), false},
+		{"max-deleted-entry-id", st.maxDeletedID.String(), false},
+		{"entries-added", strconv.FormatUint(st.entriesAdded, 10), true},
+		{"recorded-first-entry-id", st.FirstID().String(), false},
+		{"groups", strconv.Itoa(len(st.groups)), true},
+	}
+	for _, field := range info {
+		if err := respWriter(c, BULK, field.name); err != nil {
+			return err
+		}
+		kind := BULK
+		if field.integer {
+			kind = INTEGER
+		}
+		if err := respWriter(c, kind, field.value); err != nil {
+			return err
+		}
+	}
+	edges := []struct {
+		name  string
+		entry func() (StreamEntry, bool)
+	}{{"first-entry", st.First}, {"last-entry", st.Last}}
+	for _, edge := range edges {
+		if err := respWriter(c, BULK, edge.name); err != nil {
+			return err
+		}
+		entry, ok := edge.entry()
+		if !ok {
+			if _, err := c.Write([]byte("$-1\r\n")); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := respAny(c, serializeEntries([]StreamEntry{entry})[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseIntervalID parses an end of a range of IDs; ( makes it exclusive. An
+// ID of only a millisecond time gets sequence number seq. An exclusive end
+// is turned into the inclusive one next to it, first tells which end it is.
+func parseIntervalID(arg string, seq uint64, first bool) (streamID, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+		if arg == "-" || arg == "+" {
+			return streamID{}, errInvalidStreamID
+		}
+	}
+	id, err := parseStreamID(arg, seq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+	var ok bool
+	if first {
+		if id, ok = id.next(); !ok {
+			return id, errors.New("ERR invalid start ID for the interval")
+		}
+	} else if id, ok = id.prev(); !ok {
+		return id, errors.New("ERR invalid end ID for the interval")
+	}
+	return id, nil
+}
+
+// handleXgroup implements XGROUP CREATE key group id | $ [MKSTREAM], SETID
+// key group id | $, DESTROY key group, CREATECONSUMER key group consumer and
+// DELCONSUMER key group consumer.
+func handleXgroup(c *Client, args []string) error {
+	sub := strings.ToUpper(args[1])
+	switch sub {
+	case "CREATE", "SETID", "DESTROY", "CREATECONSUMER", "DELCONSUMER":
+	default:
+		return respWriter(c, ERROR, fmt.Sprintf("ERR unknown subcommand '%s'. Try XGROUP HELP.", args[1]))
+	}
+	if len(args) < 4 || (sub == "DESTROY" && len(args) != 4) ||
+		(sub != "DESTROY" && len(args) < 5) ||
+		((sub == "CREATECONSUMER" || sub == "DELCONSUMER") && len(args) != 5) {
+		return respWriter(c, ERROR, arityError("xgroup|"+sub))
+	}
+	key, group := args[2], args[3]
+	mkstream := false
+	for _, opt := range args[min(5, len(args)):] {
+		if sub != "CREATE" || !strings.EqualFold(opt, "MKSTREAM") {
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+		mkstream = true
+	}
+	st, err := GlobalStore.Stream(key)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if st == nil && !mkstream {
+		return respWriter(c, ERROR, "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	if sub != "CREATE" && sub != "DESTROY" && st.Group(group) == nil {
+		return respWriter(c, ERROR, fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", group, key))
+	}
+	switch sub {
+	case "CREATE", "SETID":
+		id := st.LastID()
+		if args[4] != "$" {
+			if id, err = parseStreamID(args[4], 0); err != nil {
+				return respWriter(c, ERROR, err.Error())
+			}
+		}
+		if sub == "SETID" {
+			GlobalStore.XGroupSetID(key, group, id)
+			return respWriter(c, SIMPLE, "OK")
+		}
+		if st.Group(group) != nil {
+			return respWriter(c, ERROR, "BUSYGROUP Consumer Group name already exists")
+		}
+		if err := GlobalStore.XGroupCreate(key, group, id); err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		return respWriter(c, SIMPLE, "OK")
+	case "DESTROY":
+		destroyed, _ := GlobalStore.XGroupDestroy(key, group)
+		if destroyed {
+			return respWriter(c, INTEGER, "1")
+		}
+		return respWriter(c, INTEGER, "0")
+	case "CREATECONSUMER":
+		if GlobalStore.XGroupCreateConsumer(key, group, args[4]) {
+			return respWriter(c, INTEGER, "1")
+		}
+		return respWriter(c, INTEGER, "0")
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(GlobalStore.XGroupDelConsumer(key, group, args[4])))
+}
+
+// handleXreadgroup implements XREADGROUP GROUP group consumer [COUNT count]
+// [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]. The ID >
+// reads entries never delivered to the group; any other ID reads the
+// entries pending for the consumer after it, which never blocks.
+func handleXreadgroup(c *Client, args []string) error {
+	var group, consumer string
+	hasGroup, noack := false, false
+	block, count := -1, 0
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "STREAMS" {
+			break
+		}
+		switch {
+		case opt == "NOACK":
+			noack = true
+		case opt == "GROUP" && i+2 < len(args):
+			group, consumer, hasGroup = args[i+1], args[i+2], true
+			i += 2
+		case (opt == "BLOCK" || opt == "COUNT") && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+			}
+			if opt == "BLOCK" {
+				if n < 0 {
+					return respWriter(c, ERROR, "ERR timeout is negative")
+				}
+				block = n
+			} else {
+				count = max(n, 0)
+			}
+			i++
+		default:
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+	}
+	params := args[min(i+1, len(args)):]
+	if len(params) == 0 || len(params)%2 != 0 {
+		return respWriter(c, ERROR, "ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+	}
+	if !hasGroup {
+		return respWriter(c, ERROR, "ERR Missing GROUP option for XREADGROUP")
+	}
+	half := len(params) / 2
+	keys := params[:half]
+	ids := make([]streamID, half)
+	next := make([]bool, half)
+	for j, arg := range params[half:] {
+		switch arg {
+		case ">":
+			next[j] = true
+		case "$":
+			return respWriter(c, ERROR, "ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		default:
+			id, err := parseStreamID(arg, 0)
+			if err != nil {
+				return respWriter(c, ERROR, err.Error())
+			}
+			ids[j] = id
+			block = -1
+		}
+	}
+	for _, key := range keys {
+		st, err := GlobalStore.Stream(key)
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		if st.Group(group) == nil {
+			return respWriter(c, ERROR, fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, group))
+		}
+	}
+	read := func() (XReadSerialized, bool) {
+		var ans XReadSerialized
+		for j, key := range keys {
+			entries := GlobalStore.XReadGroup(key, group, consumer, next[j], ids[j], count, noack)
+			if next[j] && len(entries) == 0 {
+				continue
+			}
+			ans.stream = append(ans.stream, key)
+			ans.entries = append(ans.entries, serializeEntries(entries))
+		}
+		return ans, len(ans.stream) > 0
+	}
+	if ans, ok := read(); ok {
+		return respAny(c, ans)
+	}
+	if !c.canBlock() {
+		block = -1
+	}
+	var ans XReadSerialized
+	gone := false
+	if block < 0 || !c.blockOn(keys, time.Duration(block)*time.Millisecond, func(string) bool {
+		for _, key := range keys {
+			if st, _ := GlobalStore.Stream(key); st.Group(group) == nil {
+				gone = true
+				return true
+			}
+		}
+		var ok bool
+		ans, ok = read()
+		return ok
+	}) {
+		_, err := c.Write([]byte("*-1\r\n"))
+		return err
+	}
+	if gone {
+		return respWriter(c, ERROR, "NOGROUP the consumer group this client was blocked on no longer exists")
+	}
+	return respAny(c, ans)
+}
+
+func handleXack(c *Client, args []string) error {
+	ids := make([]streamID, len(args)-3)
+	for i, arg := range args[3:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		ids[i] = id
+	}
+	n, err := GlobalStore.XAck(args[1], args[2], ids)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+// handleXpending implements XPENDING key group [[IDLE min-idle-time] start
+// end count [consumer]]. Without a range it replies with a summary: the
+// number of pending entries, the smallest and greatest pending ID and the
+// number pending for each consumer.
+func handleXpending(c *Client, args []string) error {
+	key, group := args[1], args[2]
+	minIdle := time.Duration(-1)
+	rest := args[3:]
+	if len(rest) > 0 && strings.EqualFold(rest[0], "IDLE") {
+		if len(rest) < 2 {
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+		ms, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+		}
+		minIdle, rest = time.Duration(ms)*time.Millisecond, rest[2:]
+	}
+	if (len(rest) != 0 && len(rest) != 3 && len(rest) != 4) || (minIdle >= 0 && len(rest) == 0) {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	var start, end streamID
+	count := 0
+	if len(rest) > 0 {
+		var err error
+		if start, err = parseIntervalID(rest[0], 0, true); err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		if end, err = parseIntervalID(rest[1], math.MaxUint64, false); err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		if count, err = strconv.Atoi(rest[2]); err != nil {
+			return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+		}
+	}
+	st, err := GlobalStore.Stream(key)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	g := st.Group(group)
+	if g == nil {
+		return respWriter(c, ERROR, fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+	}
+	if len(rest) == 0 {
+		return respPendingSummary(c, g)
+	}
+	pending := g.pending
+	if len(rest) == 4 {
+		cons, ok := g.consumers[rest[3]]
+		if !ok {
+			return respArrayLen(c, 0)
+		}
+		pending = cons.pending
+	}
+	var entries []*pendingEntry
+	now := time.Now()
+	if count > 0 {
+		for _, p := range pending.Range(start, end, 0) {
+			if len(entries) == count {
+				break
+			}
+			if now.Sub(p.deliveryTime) >= minIdle {
+				entries = append(entries, p)
+			}
+		}
+	}
+	if err := respArrayLen(c, len(entries)); err != nil {
+		return err
+	}
+	for _, p := range entries {
+		if err := respArrayLen(c, 4); err != nil {
+			return err
+		}
+		if err := respWriter(c, BULK, p.id.String()); err != nil {
+			return err
+		}
+		if err := respWriter(c, BULK, p.consumer.name); err != nil {
+			return err
+		}
+		if err := respWriter(c, INTEGER, strconv.FormatInt(now.Sub(p.deliveryTime).Milliseconds(), 10)); err != nil {
+			return err
+		}
+		if err := respWriter(c, INTEGER, strconv.Itoa(p.deliveryCount)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func respPendingSummary(c *Client, g *consumerGroup) error {
+	if err := respArrayLen(c, 4); err != nil {
+		return err
+	}
+	n := g.pending.Len()
+	if err := respWriter(c, INTEGER, strconv.Itoa(n)); err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := c.Write([]byte("$-1\r\n$-1\r\n*-1\r\n"))
+		return err
+	}
+	if err := respWriter(c, BULK, g.pending.ids[0].String()); err != nil {
+		return err
+	}
+	if err := respWriter(c, BULK, g.pending.ids[n-1].String()); err != nil {
+		return err
+	}
+	var names []string
+	for name, cons := range g.consumers {
+		if cons.pending.Len() > 0 {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	if err := respArrayLen(c, len(names)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := respArray(c, []string{name, strconv.Itoa(g.consumers[name].pending.Len())}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleXclaim implements XCLAIM key group consumer min-idle-time id
+// [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count]
+// [FORCE] [JUSTID] [LASTID lastid].
+func handleXclaim(c *Client, args []string) error {
+	key, group, consumer := args[1], args[2], args[3]
+	minIdle, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return respWriter(c, ERROR, "ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	var ids []streamID
+	i := 5
+	for ; i < len(args) && args[i] != "-" && args[i] != "+"; i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	now := time.Now()
+	opts := claimOptions{minIdle: time.Duration(max(minIdle, 0)) * time.Millisecond, deliveryTime: now, retryCount: -1}
+	var lastID streamID
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "FORCE":
+			opts.force = true
+		case opt == "JUSTID":
+			opts.justID = true
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT") && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return respWriter(c, ERROR, fmt.Sprintf("ERR Invalid %s option argument for XCLAIM", opt))
+			}
+			switch opt {
+			case "IDLE":
+				opts.deliveryTime = now.Add(-time.Duration(n) * time.Millisecond)
+			case "TIME":
+				opts.deliveryTime = time.UnixMilli(n)
+			default:
+				opts.retryCount = int(n)
+			}
+			i++
+		case opt == "LASTID" && i+1 < len(args):
+			if lastID, err = parseStreamID(args[i+1], 0); err != nil {
+				return respWriter(c, ERROR, err.Error())
+			}
+			i++
+		default:
+			return respWriter(c, ERROR, fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i]))
+		}
+	}
+	// A delivery time in the future, or before the epoch, means now.
+	if opts.deliveryTime.After(now) || opts.deliveryTime.UnixMilli() < 0 {
+		opts.deliveryTime = now
+	}
+	if msg := groupError(key, group); msg != "" {
+		return respWriter(c, ERROR, msg)
+	}
+	return respClaimed(c, GlobalStore.XClaim(key, group, consumer, ids, opts, lastID), opts.justID)
+}
+
+// handleXautoclaim implements XAUTOCLAIM key group consumer min-idle-time
+// start [COUNT count] [JUSTID]. It replies with the ID to continue from,
+// 0-0 once the whole pending entries list was scanned, the claimed entries
+// and the IDs of pending entries found deleted from the stream.
+func handleXautoclaim(c *Client, args []string) error {
+	key, group, consumer := args[1], args[2], args[3]
+	minIdle, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return respWriter(c, ERROR, "ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, err := parseStreamID(args[5], 0)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	now := time.Now()
+	opts := claimOptions{minIdle: time.Duration(max(minIdle, 0)) * time.Millisecond, deliveryTime: now, retryCount: -1}
+	count := 100
+	for i := 6; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "JUSTID":
+			opts.justID = true
+		case opt == "COUNT" && i+1 < len(args):
+			if count, err = strconv.Atoi(args[i+1]); err != nil {
+				return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return respWriter(c, ERROR, "ERR COUNT must be > 0")
+			}
+			i++
+		default:
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+	}
+	if msg := groupError(key, group); msg != "" {
+		return respWriter(c, ERROR, msg)
+	}
+	next, claimed, deleted := GlobalStore.XAutoClaim(key, group, consumer, start, count, opts)
+	if err := respArrayLen(c, 3); err != nil {
+		return err
+	}
+	if err := respWriter(c, BULK, next.String()); err != nil {
+		return err
+	}
+	if err := respClaimed(c, claimed, opts.justID); err != nil {
+		return err
+	}
+	deletedIDs := make([]string, len(deleted))
+	for i, id := range deleted {
+		deletedIDs[i] = id.String()
+	}
+	return respArray(c, deletedIDs)
+}
+
+// groupError returns the error reply for a command on a consumer group of
+// the stream at key, "" if the group exists.
+func groupError(key, group string) string {
+	st, err := GlobalStore.Stream(key)
+	if err != nil {
+		return err.Error()
+	}
+	if st.Group(group) == nil {
+		return fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+	}
+	return ""
+}
+
+// respClaimed replies with claimed entries, or only their IDs if justID is
+// set.
+func respClaimed(c *Client, claimed []StreamEntry, justID bool) error {
+	if !justID {
+		return respAny(c, serializeEntries(claimed))
+	}
+	ids := make([]string, len(claimed))
+	for i, entry := range claimed {
+		ids[i] = entry.ID.String()
+	}
+	return respArray(c, ids)
+}
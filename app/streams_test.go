@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitBlocked waits until a client is blocked on key.
+func waitBlocked(t *testing.T, key string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		GlobalStore.mu.Lock()
+		n := len(GlobalStore.blocked[key])
+		GlobalStore.mu.Unlock()
+		if n > 0 {
+			return
+		}
+	}
+	t.Fatalf("no client blocked on %s", key)
+}
+
+func TestXreadgroupBlockedKeyRemoved(t *testing.T) {
+	const key = "test:xreadgroup"
+	for _, remove := range [][]string{{"del", key}, {"set", key, "v"}} {
+		blocked, other := newTestClient(t), newTestClient(t)
+		if reply, err := other.Do("xgroup", "create", key, "g", "$", "mkstream"); reply != "OK" {
+			t.Fatalf("XGROUP CREATE replied %v, %v", reply, err)
+		}
+		if err := blocked.Send("xreadgroup", "group", "g", "c", "block", "0", "streams", key, ">"); err != nil {
+			t.Fatal(err)
+		}
+		waitBlocked(t, key)
+		if _, err := other.Do(remove...); err != nil {
+			t.Fatal(err)
+		}
+		blocked.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply, err := readReply(blocked.r)
+		if e, _ := reply.(replyError); !strings.HasPrefix(string(e), "NOGROUP") {
+			t.Fatalf("XREADGROUP blocked on a key removed by %s replied %v, %v", remove[0], reply, err)
+		}
+		other.Do("del", key)
+	}
+}
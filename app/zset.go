@@ -0,0 +1,152 @@
+package main
+
+import "maps"
+
+// sortedSet is the value of a sorted set: a skip list ordering the members
+// by score plus a map from member to score, so that both ranges and single
+// members are found quickly.
+type sortedSet struct {
+	zsl  *zskiplist
+	dict map[string]float64
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{zsl: newZskiplist(), dict: make(map[string]float64)}
+}
+
+// Len returns the number of members. A nil sorted set is empty.
+func (z *sortedSet) Len() int {
+	if z == nil {
+		return 0
+	}
+	return len(z.dict)
+}
+
+func (z *sortedSet) Score(member string) (float64, bool) {
+	if z == nil {
+		return 0, false
+	}
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Set adds member with score, or moves it to score if it is a member.
+func (z *sortedSet) Set(member string, score float64) {
+	if cur, ok := z.dict[member]; ok {
+		if cur == score {
+			return
+		}
+		z.zsl.Delete(cur, member)
+	}
+	z.zsl.Insert(score, member)
+	z.dict[member] = score
+}
+
+// Remove removes member and reports whether it was a member.
+func (z *sortedSet) Remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.zsl.Delete(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+// Rank returns the 0-based rank of member, counting from the highest score
+// if reverse is set.
+func (z *sortedSet) Rank(member string, reverse bool) (int, bool) {
+	score, ok := z.Score(member)
+	if !ok {
+		return 0, false
+	}
+	rank := z.zsl.Rank(score, member)
+	if reverse {
+		return z.Len() - rank, true
+	}
+	return rank - 1, true
+}
+
+// Count returns the number of members with a score in r.
+func (z *sortedSet) Count(r scoreRange) int {
+	if z == nil {
+		return 0
+	}
+	first := z.zsl.FirstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.LastInRange(r)
+	return z.zsl.Rank(last.score, last.member) - z.zsl.Rank(first.score, first.member) + 1
+}
+
+// RangeByRank returns the members from rank start to stop, which must be
+// clamped to the set already, see listRange. With reverse set ranks count
+// from the highest score.
+func (z *sortedSet) RangeByRank(start, stop int, reverse bool) []*zskiplistNode {
+	if z == nil || start > stop || start >= z.Len() {
+		return nil
+	}
+	n := stop - start + 1
+	var x *zskiplistNode
+	if reverse {
+		x = z.zsl.ByRank(z.Len() - start)
+	} else {
+		x = z.zsl.ByRank(start + 1)
+	}
+	return walk(x, reverse, 0, n, func(*zskiplistNode) bool { return true })
+}
+
+// RangeByScore returns the members with a score in r, skipping offset of
+// them and returning at most count unless count is negative.
+func (z *sortedSet) RangeByScore(r scoreRange, reverse bool, offset, count int) []*zskiplistNode {
+	if z == nil {
+		return nil
+	}
+	if reverse {
+		return walk(z.zsl.LastInRange(r), true, offset, count, func(n *zskiplistNode) bool { return r.gteMin(n.score) })
+	}
+	return walk(z.zsl.FirstInRange(r), false, offset, count, func(n *zskiplistNode) bool { return r.lteMax(n.score) })
+}
+
+// RangeByLex is RangeByScore for a range of members.
+func (z *sortedSet) RangeByLex(r lexRange, reverse bool, offset, count int) []*zskiplistNode {
+	if z == nil {
+		return nil
+	}
+	if reverse {
+		return walk(z.zsl.LastInLexRange(r), true, offset, count, func(n *zskiplistNode) bool { return r.gteMin(n.member) })
+	}
+	return walk(z.zsl.FirstInLexRange(r), false, offset, count, func(n *zskiplistNode) bool { return r.lteMax(n.member) })
+}
+
+// walk follows the list from x, backwards if reverse is set, while inRange
+// holds. It skips offset nodes and collects at most count, all of them if
+// count is negative.
+func walk(x *zskiplistNode, reverse bool, offset, count int, inRange func(*zskiplistNode) bool) []*zskiplistNode {
+	var nodes []*zskiplistNode
+	for ; x != nil && count != 0 && inRange(x); x = x.next(reverse) {
+		if offset > 0 {
+			offset--
+			continue
+		}
+		nodes = append(nodes, x)
+		count--
+	}
+	return nodes
+}
+
+func (n *zskiplistNode) next(reverse bool) *zskiplistNode {
+	if reverse {
+		return n.backward
+	}
+	return n.level[0].forward
+}
+
+func (z *sortedSet) Clone() *sortedSet {
+	c := &sortedSet{zsl: newZskiplist(), dict: maps.Clone(z.dict)}
+	for member, score := range z.dict {
+		c.zsl.Insert(score, member)
+	}
+	return c
+}
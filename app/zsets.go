@@ -0,0 +1,323 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseScore parses a score, which may be inf or -inf but not NaN.
+func parseScore(arg string) (float64, bool) {
+	score, err := strconv.ParseFloat(arg, 64)
+	return score, err == nil && !math.IsNaN(score)
+}
+
+// formatScore formats a score the way Redis replies with it: the shortest
+// representation that parses back to it, inf and -inf for infinities.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	case score != 0 && (math.Abs(score) < 1e-4 || math.Abs(score) >= 1e17):
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// parseScoreBound parses an end of a score range; ( makes it exclusive.
+func parseScoreBound(arg string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, ok := parseScore(arg)
+	return score, exclusive, ok
+}
+
+func parseScoreRange(min, max string) (scoreRange, bool) {
+	var r scoreRange
+	var ok1, ok2 bool
+	r.min, r.minex, ok1 = parseScoreBound(min)
+	r.max, r.maxex, ok2 = parseScoreBound(max)
+	return r, ok1 && ok2
+}
+
+// parseLexBound parses an end of a range of members: - or +, or a member
+// preceded by [ if inclusive or ( if exclusive.
+func parseLexBound(arg string) (lexBound, bool) {
+	switch {
+	case arg == "-":
+		return lexBound{inf: -1}, true
+	case arg == "+":
+		return lexBound{inf: 1}, true
+	case strings.HasPrefix(arg, "["):
+		return lexBound{member: arg[1:]}, true
+	case strings.HasPrefix(arg, "("):
+		return lexBound{member: arg[1:], exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+// handleZadd implements ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member
+// [score member ...].
+func handleZadd(c *Client, args []string) error {
+	var flags zaddFlags
+	var ch, incr bool
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "GT":
+			flags.gt = true
+		case "LT":
+			flags.lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	if flags.nx && flags.xx {
+		return respWriter(c, ERROR, "ERR XX and NX options at the same time are not compatible")
+	}
+	if (flags.gt && flags.nx) || (flags.lt && flags.nx) || (flags.gt && flags.lt) {
+		return respWriter(c, ERROR, "ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return respWriter(c, ERROR, "ERR INCR option supports a single increment-element pair")
+	}
+	scores := make([]float64, len(pairs)/2)
+	members := make([]string, len(pairs)/2)
+	for j := range scores {
+		score, ok := parseScore(pairs[2*j])
+		if !ok {
+			return respWriter(c, ERROR, "ERR value is not a valid float")
+		}
+		scores[j], members[j] = score, pairs[2*j+1]
+	}
+	if incr {
+		score, ok, err := GlobalStore.ZIncrBy(args[1], flags, scores[0], members[0])
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		if !ok {
+			_, err := c.Write([]byte("$-1\r\n"))
+			return err
+		}
+		return respWriter(c, BULK, formatScore(score))
+	}
+	added, updated, err := GlobalStore.ZAdd(args[1], flags, scores, members)
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	if ch {
+		added += updated
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(added))
+}
+
+func handleZincrby(c *Client, args []string) error {
+	incr, ok := parseScore(args[2])
+	if !ok {
+		return respWriter(c, ERROR, "ERR value is not a valid float")
+	}
+	score, _, err := GlobalStore.ZIncrBy(args[1], zaddFlags{}, incr, args[3])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, BULK, formatScore(score))
+}
+
+func handleZrem(c *Client, args []string) error {
+	n, err := GlobalStore.ZRem(args[1], args[2:])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(n))
+}
+
+// handleZscore implements ZSCORE and ZMSCORE.
+func handleZscore(c *Client, args []string) error {
+	zset, err := GlobalStore.ZSet(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	multi := strings.EqualFold(args[0], "zmscore")
+	if multi {
+		if err := respArrayLen(c, len(args)-2); err != nil {
+			return err
+		}
+	}
+	for _, member := range args[2:] {
+		score, ok := zset.Score(member)
+		if !ok {
+			if _, err := c.Write([]byte("$-1\r\n")); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := respWriter(c, BULK, formatScore(score)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleZcard(c *Client, args []string) error {
+	zset, err := GlobalStore.ZSet(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(zset.Len()))
+}
+
+func handleZcount(c *Client, args []string) error {
+	r, ok := parseScoreRange(args[2], args[3])
+	if !ok {
+		return respWriter(c, ERROR, "ERR min or max is not a float")
+	}
+	zset, err := GlobalStore.ZSet(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	return respWriter(c, INTEGER, strconv.Itoa(zset.Count(r)))
+}
+
+// handleZrank implements ZRANK and ZREVRANK key member [WITHSCORE].
+func handleZrank(c *Client, args []string) error {
+	if len(args) > 4 || (len(args) == 4 && !strings.EqualFold(args[3], "WITHSCORE")) {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	withScore := len(args) == 4
+	zset, err := GlobalStore.ZSet(args[1])
+	if err != nil {
+		return respWriter(c, ERROR, err.Error())
+	}
+	rank, ok := zset.Rank(args[2], strings.EqualFold(args[0], "zrevrank"))
+	if !ok {
+		if withScore {
+			_, err := c.Write([]byte("*-1\r\n"))
+			return err
+		}
+		_, err := c.Write([]byte("$-1\r\n"))
+		return err
+	}
+	if !withScore {
+		return respWriter(c, INTEGER, strconv.Itoa(rank))
+	}
+	score, _ := zset.Score(args[2])
+	if err := respArrayLen(c, 2); err != nil {
+		return err
+	}
+	if err := respWriter(c, INTEGER, strconv.Itoa(rank)); err != nil {
+		return err
+	}
+	return respWriter(c, BULK, formatScore(score))
+}
+
+// handleZrange implements ZRANGE key start stop [BYSCORE | BYLEX] [REV]
+// [LIMIT offset count] [WITHSCORES]. With REV, start and stop of a score or
+// member range are the high and the low end.
+func handleZrange(c *Client, args []string) error {
+	var byScore, byLex, rev, withScores, limit bool
+	offset, count := 0, -1
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BYSCORE":
+			byScore = true
+		case "BYLEX":
+			byLex = true
+		case "REV":
+			rev = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return respWriter(c, ERROR, "ERR syntax error")
+			}
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(args[i+1])
+			count, err2 = strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+			}
+			limit = true
+			i += 2
+		default:
+			return respWriter(c, ERROR, "ERR syntax error")
+		}
+	}
+	if byScore && byLex {
+		return respWriter(c, ERROR, "ERR syntax error")
+	}
+	if limit && !byScore && !byLex {
+		return respWriter(c, ERROR, "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && byLex {
+		return respWriter(c, ERROR, "ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	min, max := args[2], args[3]
+	if rev && (byScore || byLex) {
+		min, max = max, min
+	}
+	var nodes []*zskiplistNode
+	switch {
+	case byScore:
+		r, ok := parseScoreRange(min, max)
+		if !ok {
+			return respWriter(c, ERROR, "ERR min or max is not a float")
+		}
+		zset, err := GlobalStore.ZSet(args[1])
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		if offset >= 0 {
+			nodes = zset.RangeByScore(r, rev, offset, count)
+		}
+	case byLex:
+		lo, ok1 := parseLexBound(min)
+		hi, ok2 := parseLexBound(max)
+		if !ok1 || !ok2 {
+			return respWriter(c, ERROR, "ERR min or max not valid string range item")
+		}
+		zset, err := GlobalStore.ZSet(args[1])
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		if offset >= 0 {
+			nodes = zset.RangeByLex(lexRange{min: lo, max: hi}, rev, offset, count)
+		}
+	default:
+		start, err1 := strconv.Atoi(args[2])
+		stop, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil {
+			return respWriter(c, ERROR, "ERR value is not an integer or out of range")
+		}
+		zset, err := GlobalStore.ZSet(args[1])
+		if err != nil {
+			return respWriter(c, ERROR, err.Error())
+		}
+		start, stop = listRange(start, stop, zset.Len())
+		nodes = zset.RangeByRank(start, stop, rev)
+	}
+	reply := make([]string, 0, 2*len(nodes))
+	for _, n := range nodes {
+		reply = append(reply, n.member)
+		if withScores {
+			reply = append(reply, formatScore(n.score))
+		}
+	}
+	return respArray(c, reply)
+}